@@ -0,0 +1,170 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"github.com/cfmobile/levolib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//-zip writes DEFAULT_ARCHIVE_NAME in the output root
+const DEFAULT_ARCHIVE_NAME string = "levo_gen.zip"
+
+//-archive - writes a zip to stdout
+const ARCHIVE_STDOUT string = "-"
+
+//archiveEntry is a generated file as it is stored in an archive
+type archiveEntry struct {
+	Name     string
+	Contents []byte
+	Mode     os.FileMode
+}
+
+//eachArchiveEntry renders files into archive entries the same way as files
+//are planned for the disk, and hands them to write one at a time. Only the paths
+//and snippets of files are kept across entries, so a large archive is never
+//held in memory. An archive starts out empty, so snippets can only be injected
+//into files generated in the same run.
+func eachArchiveEntry(files []outputFile, write func(archiveEntry) error) error {
+	for _, file := range files {
+		planned, err := planFile(file, file.Path, readNoExistingFile)
+		if err != nil {
+			return err
+		}
+		if err := write(archiveEntry{Name: file.Path, Contents: planned.Contents, Mode: planned.Mode}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//readNoExistingFile stands in for readExistingFile when nothing exists yet
+func readNoExistingFile(path string) ([]byte, os.FileMode, error) {
+	return nil, 0, os.ErrNotExist
+}
+
+//archiveWriter adds entries to an archive in one of the supported formats
+type archiveWriter interface {
+	writeEntry(entry archiveEntry) error
+	Close() error
+}
+
+//newArchiveWriter picks the archive format from the extension of path
+func newArchiveWriter(path string, writer io.Writer) (archiveWriter, error) {
+	lowerPath := strings.ToLower(path)
+	if strings.HasSuffix(lowerPath, ".zip") {
+		return &zipArchiveWriter{zip: zip.NewWriter(writer)}, nil
+	} else if strings.HasSuffix(lowerPath, ".tar") {
+		return &tarArchiveWriter{tar: tar.NewWriter(writer)}, nil
+	} else if strings.HasSuffix(lowerPath, ".tar.gz") || strings.HasSuffix(lowerPath, ".tgz") {
+		gzipWriter := gzip.NewWriter(writer)
+		return &tarArchiveWriter{tar: tar.NewWriter(gzipWriter), gzip: gzipWriter}, nil
+	}
+	return nil, errors.New("Unknown archive format for " + path + ". Use .zip, .tar, .tar.gz or .tgz")
+}
+
+type zipArchiveWriter struct {
+	zip *zip.Writer
+}
+
+func (self *zipArchiveWriter) writeEntry(entry archiveEntry) error {
+	header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate}
+	header.SetMode(entry.Mode)
+	file, err := self.zip.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(entry.Contents)
+	return err
+}
+
+func (self *zipArchiveWriter) Close() error {
+	return self.zip.Close()
+}
+
+//tarArchiveWriter writes a tarball, compressed if gzip is set
+type tarArchiveWriter struct {
+	tar  *tar.Writer
+	gzip *gzip.Writer
+}
+
+func (self *tarArchiveWriter) writeEntry(entry archiveEntry) error {
+	header := &tar.Header{
+		Name:     entry.Name,
+		Mode:     int64(entry.Mode),
+		Size:     int64(len(entry.Contents)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+	}
+	if err := self.tar.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := self.tar.Write(entry.Contents)
+	return err
+}
+
+func (self *tarArchiveWriter) Close() error {
+	if err := self.tar.Close(); err != nil {
+		return err
+	}
+	if self.gzip != nil {
+		return self.gzip.Close()
+	}
+	return nil
+}
+
+//writeArchive streams the generated files into the archive at path, which is
+//only replaced once the whole archive was written. A path of ARCHIVE_STDOUT
+//streams a zip to stdout instead.
+func writeArchive(generatedFiles []levo.GeneratedFile, path string) error {
+	files, err := collectOutputFiles(generatedFiles, archiveEntryName)
+	if err != nil {
+		return err
+	}
+	if path == ARCHIVE_STDOUT {
+		return streamArchive(files, DEFAULT_ARCHIVE_NAME, os.Stdout)
+	}
+	//fail on an unknown format before creating anything
+	if _, err := newArchiveWriter(path, ioutil.Discard); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeAtomically(path, DEFAULT_FILE_MODE, func(writer io.Writer) error {
+		return streamArchive(files, path, writer)
+	})
+}
+
+//streamArchive writes files to writer in the format path calls for
+func streamArchive(files []outputFile, path string, writer io.Writer) error {
+	archive, err := newArchiveWriter(path, writer)
+	if err != nil {
+		return err
+	}
+	if err := eachArchiveEntry(files, archive.writeEntry); err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -0,0 +1,210 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"github.com/cfmobile/levolib"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+//planArchive collects the entries an archive of generatedFiles holds
+func planArchive(generatedFiles []levo.GeneratedFile) ([]archiveEntry, error) {
+	files, err := collectOutputFiles(generatedFiles, archiveEntryName)
+	if err != nil {
+		return []archiveEntry{}, err
+	}
+	entries := make([]archiveEntry, 0)
+	err = eachArchiveEntry(files, func(entry archiveEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries, err
+}
+
+func TestPlanArchive(testing *testing.T) {
+	icon := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "icon.png", Directory: "res", Body: []byte(BASE64_HEADER + base64.StdEncoding.EncodeToString(icon))},
+		levo.GeneratedFile{FileName: "Routes.java", Directory: "src", Body: []byte("// routes\n")},
+		levo.GeneratedFile{FileName: "Routes.java", Directory: "src", Body: []byte("<<levoinject after:// routes>>\nadd(Cats);\n")},
+	}
+	entries, err := planArchive(generatedFiles)
+	if err != nil {
+		testing.Fatalf("Error planning archive: %v", err.Error())
+	}
+	if len(entries) != 2 {
+		testing.Fatalf("Expected 2 entries. Got %v", len(entries))
+	}
+	if entries[0].Name != "res/icon.png" || !bytes.Equal(entries[0].Contents, icon) {
+		testing.Errorf("Binary entry was not decoded: %v %q", entries[0].Name, entries[0].Contents)
+	}
+	if string(entries[1].Contents) != "// routes\nadd(Cats);\n" {
+		testing.Errorf("Snippet was not injected into the archive entry: %q", entries[1].Contents)
+	}
+
+	//as on disk, the order of the mappings does not matter
+	injectedFirst := []levo.GeneratedFile{generatedFiles[2], generatedFiles[1]}
+	if entries, err := planArchive(injectedFirst); err != nil || len(entries) != 1 || string(entries[0].Contents) != "// routes\nadd(Cats);\n" {
+		testing.Errorf("Snippet injected before its file was generated did not end up in the archive: %v %v", entries, err)
+	}
+
+	missing := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Missing.java", Body: []byte("<<levoinject after:x>>\ny\n")}}
+	if _, err := planArchive(missing); err == nil {
+		testing.Errorf("No error when injecting into a file that is not in the archive")
+	}
+}
+
+func TestArchiveEntriesStream(testing *testing.T) {
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "icon.png", Body: []byte(BASE64_HEADER + "not base64!")},
+	}
+	files, err := collectOutputFiles(generatedFiles, archiveEntryName)
+	if err != nil {
+		testing.Fatalf("Error collecting files: %v", err.Error())
+	}
+	written := make([]string, 0)
+	err = eachArchiveEntry(files, func(entry archiveEntry) error {
+		written = append(written, entry.Name)
+		return nil
+	})
+	if err == nil || len(written) != 1 || written[0] != "Cats.java" {
+		testing.Errorf("Expected Cats.java to be written before icon.png failed to render. Wrote %v, %v", written, err)
+	}
+}
+
+func TestWriteZipFileContents(testing *testing.T) {
+	defer cleanup()
+	icon := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "icon.png", Directory: "res", Body: []byte(BASE64_HEADER + base64.StdEncoding.EncodeToString(icon))},
+		levo.GeneratedFile{FileName: "gradlew", Body: []byte("<<levomode:0755>>\n" + BASE64_HEADER + base64.StdEncoding.EncodeToString([]byte("#!/bin/sh\n")))},
+	}
+	if err := writeZipFile(generatedFiles); err != nil {
+		testing.Fatalf("Error writing zip: %v", err.Error())
+	}
+
+	zipReader, err := zip.OpenReader("levo_gen.zip")
+	if err != nil {
+		testing.Fatalf("Could not open the zip: %v", err.Error())
+	}
+	defer zipReader.Close()
+	if len(zipReader.File) != 2 || zipReader.File[0].Name != "res/icon.png" {
+		testing.Fatalf("Unexpected zip entries")
+	}
+	if zipReader.File[0].Mode().Perm() != DEFAULT_FILE_MODE || zipReader.File[1].Mode().Perm() != 0755 {
+		testing.Errorf("Unexpected zip entry modes %o %o", zipReader.File[0].Mode().Perm(), zipReader.File[1].Mode().Perm())
+	}
+	file, err := zipReader.File[0].Open()
+	if err != nil {
+		testing.Fatalf("Could not open the zip entry: %v", err.Error())
+	}
+	defer file.Close()
+	contents, _ := ioutil.ReadAll(file)
+	if !bytes.Equal(contents, icon) {
+		testing.Errorf("Zipped binary was not decoded: %q", contents)
+	}
+}
+
+func TestWriteArchiveFormats(testing *testing.T) {
+	outputDir := testTempDir(testing, "levo-archive")
+	defer os.RemoveAll(outputDir)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Directory: "src/models", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "gradlew", Body: []byte("<<levomode:0755>>\n#!/bin/sh\n")},
+	}
+	for _, name := range []string{"out.tar", "out.tar.gz", "out.tgz"} {
+		path := filepath.Join(outputDir, name)
+		if err := writeArchive(generatedFiles, path); err != nil {
+			testing.Fatalf("Error writing %v: %v", name, err.Error())
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			testing.Fatalf("Could not open %v: %v", name, err.Error())
+		}
+		var reader io.Reader = file
+		if name != "out.tar" {
+			gzipReader, err := gzip.NewReader(file)
+			if err != nil {
+				testing.Fatalf("%v is not gzipped: %v", name, err.Error())
+			}
+			reader = gzipReader
+		}
+		tarReader := tar.NewReader(reader)
+		header, err := tarReader.Next()
+		if err != nil || header.Name != "src/models/Cats.java" || header.Mode != 0644 {
+			testing.Errorf("Unexpected first entry in %v: %v", name, header)
+		}
+		contents, _ := ioutil.ReadAll(tarReader)
+		if string(contents) != "class Cats {}\n" {
+			testing.Errorf("Unexpected contents in %v: %q", name, contents)
+		}
+		header, err = tarReader.Next()
+		if err != nil || header.Name != "gradlew" || header.Mode != 0755 {
+			testing.Errorf("Unexpected second entry in %v: %v", name, header)
+		}
+		file.Close()
+	}
+
+	if err := writeArchive(generatedFiles, filepath.Join(outputDir, "out.rar")); err == nil {
+		testing.Errorf("No error for an unknown archive format")
+	}
+}
+
+func TestArchiveFromConfig(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	if _, err := generateFromConfiguration("test-resources/code-gen-config-zip.json"); err != nil {
+		testing.Fatalf("Error processing config: %v", err.Error())
+	}
+	if !zipOutput || archivePath != "test-output/models.tar.gz" {
+		testing.Errorf("Zip and Archive were not read from the config: %v %v", zipOutput, archivePath)
+	}
+}
+
+func TestWriteArchiveToStdout(testing *testing.T) {
+	output, err := ioutil.TempFile("", "levo-stdout")
+	if err != nil {
+		testing.Fatalf("Could not create a temporary file (not a code failure): %v", err.Error())
+	}
+	defer os.Remove(output.Name())
+	stdout := os.Stdout
+	os.Stdout = output
+	err = writeArchive([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")}}, ARCHIVE_STDOUT)
+	os.Stdout = stdout
+	output.Close()
+	if err != nil {
+		testing.Fatalf("Error writing the archive to stdout: %v", err.Error())
+	}
+
+	zipReader, err := zip.OpenReader(output.Name())
+	if err != nil {
+		testing.Fatalf("Stdout did not receive a zip: %v", err.Error())
+	}
+	defer zipReader.Close()
+	if len(zipReader.File) != 1 || zipReader.File[0].Name != "Cats.java" {
+		testing.Errorf("Unexpected zip entries")
+	}
+}
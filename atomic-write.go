@@ -0,0 +1,57 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//writeAtomically streams the output of write into a temporary file next to
+//path and renames it into place once everything was written, so that a failed
+//or interrupted run never leaves a truncated file behind.
+func writeAtomically(path string, mode os.FileMode, write func(writer io.Writer) error) error {
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".levo-tmp")
+	if err != nil {
+		return err
+	}
+	err = write(tempFile)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempFile.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return nil
+}
+
+func writeBytesAtomically(path string, contents []byte, mode os.FileMode) error {
+	return writeAtomically(path, mode, func(writer io.Writer) error {
+		_, err := writer.Write(contents)
+		return err
+	})
+}
@@ -0,0 +1,60 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAtomically(testing *testing.T) {
+	outputDir := testTempDir(testing, "levo-atomic")
+	defer os.RemoveAll(outputDir)
+	path := filepath.Join(outputDir, "levo_gen.zip")
+	ioutil.WriteFile(path, []byte("previous"), 0644)
+
+	//a failed write leaves the previous file alone
+	err := writeAtomically(path, 0644, func(writer io.Writer) error {
+		writer.Write([]byte("half"))
+		return errors.New("disk full")
+	})
+	if err == nil {
+		testing.Errorf("The error of the writer was not returned")
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if string(contents) != "previous" {
+		testing.Errorf("A failed write replaced the file: %q", contents)
+	}
+	if files, _ := ioutil.ReadDir(outputDir); len(files) != 1 {
+		testing.Errorf("A temporary file was left behind")
+	}
+
+	err = writeAtomically(path, 0640, func(writer io.Writer) error {
+		_, err := writer.Write([]byte("complete"))
+		return err
+	})
+	if err != nil {
+		testing.Fatalf("Error writing atomically: %v", err.Error())
+	}
+	contents, _ = ioutil.ReadFile(path)
+	fileInfo, _ := os.Stat(path)
+	if string(contents) != "complete" || fileInfo.Mode().Perm() != 0640 {
+		testing.Errorf("Unexpected file %q with mode %o", contents, fileInfo.Mode().Perm())
+	}
+}
@@ -0,0 +1,181 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"github.com/cfmobile/levolib"
+	"path/filepath"
+	"strings"
+)
+
+//pathCollision is a group of generated files that end up at the same path, or
+//at paths that only differ in case
+type pathCollision struct {
+	Key   string
+	Files []levo.GeneratedFile
+}
+
+func generatedFilePath(generatedFile levo.GeneratedFile) string {
+	return filepath.Clean(filepath.Join(generatedFile.Directory, generatedFile.FileName))
+}
+
+//collisionKey is the same for two paths that are the same file on a case
+//insensitive file system, such as the default one on macOS
+func collisionKey(path string) string {
+	return strings.ToLower(filepath.ToSlash(path))
+}
+
+//findPathCollisions groups the generated files that would overwrite each other.
+//A file generated twice with the same contents is not a collision, and neither
+//is a snippet injected into a file at exactly the same path, since snippets
+//are folded into their file whatever the order. A snippet aimed at a path that
+//only differs in case from a generated file is a collision.
+func findPathCollisions(generatedFiles []levo.GeneratedFile) []pathCollision {
+	collisions := make([]pathCollision, 0)
+	indexes := make(map[string]int)
+	for _, generatedFile := range generatedFiles {
+		key := collisionKey(generatedFilePath(generatedFile))
+		i, found := indexes[key]
+		if !found {
+			indexes[key] = len(collisions)
+			collisions = append(collisions, pathCollision{Key: key, Files: []levo.GeneratedFile{generatedFile}})
+			continue
+		}
+		collisions[i].Files = append(collisions[i].Files, generatedFile)
+	}
+
+	colliding := make([]pathCollision, 0)
+	for _, collision := range collisions {
+		if collidesWithin(collision.Files) {
+			colliding = append(colliding, collision)
+		}
+	}
+	return colliding
+}
+
+//collidesWithin is true if files, which share a collision key, are not all
+//the same file generated the same way or snippets injected into it
+func collidesWithin(files []levo.GeneratedFile) bool {
+	var whole *levo.GeneratedFile
+	for i := range files {
+		if _, isInjection, _ := parseSnippetInjection(files[i].Body); isInjection {
+			continue
+		}
+		if whole == nil {
+			whole = &files[i]
+		} else if generatedFilePath(files[i]) != generatedFilePath(*whole) || !bytes.Equal(files[i].Body, whole.Body) {
+			return true
+		}
+	}
+	if whole == nil {
+		//snippets alone are injected into files on disk, where the case of the
+		//path decides which file they end up in
+		for _, generatedFile := range files[1:] {
+			if generatedFilePath(generatedFile) != generatedFilePath(files[0]) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, generatedFile := range files {
+		if generatedFilePath(generatedFile) != generatedFilePath(*whole) {
+			return true
+		}
+	}
+	return false
+}
+
+//generatedFileSources finds out which template and model generate each path,
+//by generating every template once for every model of the mappings. That is
+//only worth doing to explain a collision.
+func generatedFileSources(context levo.Context, mappings []modelToTemplateMapping) map[string][]string {
+	sources := make(map[string][]string)
+	for _, mapping := range mappings {
+		modelNames := mapping.ModelNames
+		if len(modelNames) == 0 {
+			modelNames = []string{""}
+		}
+		for _, templateName := range mapping.TemplateNames {
+			for _, modelName := range modelNames {
+				singleContext := context
+				singleContext.Mappings = nil
+				source := "template " + templateName
+				singleModel := []string{}
+				if modelName != "" {
+					source += " for model " + modelName
+					singleModel = []string{modelName}
+				}
+				if err := singleContext.AddTemplatesForModelsMapping([]string{templateName}, singleModel); err != nil {
+					continue
+				}
+				generatedFiles, err := levo.ProcessMappings(singleContext)
+				if err != nil {
+					continue
+				}
+				for _, generatedFile := range generatedFiles {
+					path := generatedFilePath(generatedFile)
+					sources[path] = appendMissing(sources[path], source)
+				}
+			}
+		}
+	}
+	return sources
+}
+
+func appendMissing(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+//pathCollisionError names every colliding path along with the templates and
+//models that generate it
+func pathCollisionError(collisions []pathCollision, sources map[string][]string) error {
+	lines := make([]string, 0, len(collisions))
+	for _, collision := range collisions {
+		paths := make([]string, 0)
+		for _, generatedFile := range collision.Files {
+			paths = appendMissing(paths, generatedFilePath(generatedFile))
+		}
+		described := make([]string, 0, len(paths))
+		for _, path := range paths {
+			if len(sources[path]) > 0 {
+				described = append(described, path+" ("+strings.Join(sources[path], ", ")+")")
+			} else {
+				described = append(described, path)
+			}
+		}
+		if len(paths) == 1 {
+			lines = append(lines, described[0]+" is generated more than once with different contents")
+		} else {
+			lines = append(lines, strings.Join(described, " and ")+" only differ in case, and are the same file on macOS")
+		}
+	}
+	return newLevoError(TemplateError, "Generated files collide:\n\t"+strings.Join(lines, "\n\t"))
+}
+
+//checkPathCollisions fails if two generated files would overwrite each other,
+//instead of letting the last one win
+func checkPathCollisions(generatedFiles []levo.GeneratedFile, context levo.Context, mappings []modelToTemplateMapping) error {
+	collisions := findPathCollisions(generatedFiles)
+	if len(collisions) == 0 {
+		return nil
+	}
+	return pathCollisionError(collisions, generatedFileSources(context, mappings))
+}
@@ -0,0 +1,78 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindPathCollisions(testing *testing.T) {
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Dogs.generic", Body: []byte("dogs")},
+		levo.GeneratedFile{FileName: "Dogs.generic", Body: []byte("dogs")},
+		levo.GeneratedFile{FileName: "Module.java", Body: []byte("<<levoinject after:models>>\ncats\n")},
+		levo.GeneratedFile{FileName: "Module.java", Body: []byte("models")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("routes")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("<<levoinject after:routes>>\ndogs\n")},
+	}
+	if collisions := findPathCollisions(generatedFiles); len(collisions) != 0 {
+		testing.Errorf("Identical files and injections were seen as collisions: %v", collisions)
+	}
+
+	//a snippet aimed at another case of a generated file ends up elsewhere on Linux
+	misaimed := append(generatedFiles, levo.GeneratedFile{FileName: "routes.java", Body: []byte("<<levoinject after:routes>>\ncats\n")})
+	if collisions := findPathCollisions(misaimed); len(collisions) != 1 || len(collisions[0].Files) != 3 {
+		testing.Errorf("Snippet for a path that only differs in case was not a collision: %v", collisions)
+	}
+
+	generatedFiles = append(generatedFiles,
+		levo.GeneratedFile{FileName: "Cats.java", Directory: "src", Body: []byte("cats")},
+		levo.GeneratedFile{FileName: "cats.java", Directory: "src/", Body: []byte("cats")},
+		levo.GeneratedFile{FileName: "Dogs.generic", Body: []byte("other dogs")},
+	)
+	collisions := findPathCollisions(generatedFiles)
+	if len(collisions) != 2 || collisions[0].Key != "dogs.generic" || collisions[1].Key != "src/cats.java" {
+		testing.Fatalf("Unexpected collisions %v", collisions)
+	}
+
+	message := pathCollisionError(collisions, map[string][]string{"Dogs.generic": []string{"template A.lt for model Dogs", "template B.lt"}}).Error()
+	if !strings.Contains(message, "Dogs.generic (template A.lt for model Dogs, template B.lt) is generated more than once") {
+		testing.Errorf("Duplicate was not attributed to its templates:\n%s", message)
+	}
+	if !strings.Contains(message, filepath.Join("src", "Cats.java")+" and "+filepath.Join("src", "cats.java")+" only differ in case") {
+		testing.Errorf("Case collision was not reported:\n%s", message)
+	}
+}
+
+func TestGenerateReportsPathCollisions(testing *testing.T) {
+	defer cleanup()
+	templateDir := testTempDir(testing, "levo-collision")
+	defer os.RemoveAll(templateDir)
+	ioutil.WriteFile(filepath.Join(templateDir, "A.lt"), []byte("{{range .Models}}<<levo filename:{{.Name}}.generic>>\nA\n<<levo>>\n{{end}}"), 0644)
+	ioutil.WriteFile(filepath.Join(templateDir, "B.lt"), []byte("<<levo filename:Dogs.generic>>\nB\n<<levo>>\n"), 0644)
+
+	_, err := generateModelsAndTemplates([]levo.Model{levo.Model{Name: "Cats"}, levo.Model{Name: "Dogs"}}, templateDir)
+	if !isErrorKind(err, TemplateError) {
+		testing.Fatalf("Expected a template error for colliding files. Got %v", err)
+	}
+	if !strings.Contains(err.Error(), "Dogs.generic (template A.lt for model Dogs, template B.lt for model Cats, template B.lt for model Dogs)") {
+		testing.Errorf("Collision was not attributed to its templates and models:\n%v", err)
+	}
+}
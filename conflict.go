@@ -0,0 +1,155 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//What to do when a generated file already exists on disk
+const CONFLICT_ASK string = "ask"
+const CONFLICT_OVERWRITE string = "overwrite"
+const CONFLICT_SKIP string = "skip"
+const CONFLICT_FAIL string = "fail"
+const CONFLICT_BACKUP string = "backup"
+const CONFLICT_ALONGSIDE string = "alongside"
+
+//backup keeps the existing file with BACKUP_SUFFIX appended, and alongside
+//writes the generated file next to it with ALONGSIDE_SUFFIX appended
+const BACKUP_SUFFIX string = ".orig"
+const ALONGSIDE_SUFFIX string = ".new"
+
+func validConflictPolicy(policy string) bool {
+	switch policy {
+	case CONFLICT_ASK, CONFLICT_OVERWRITE, CONFLICT_SKIP, CONFLICT_FAIL, CONFLICT_BACKUP, CONFLICT_ALONGSIDE:
+		return true
+	}
+	return false
+}
+
+//conflictRule applies Policy to the files matching Pattern, e.g. never
+//overwrite "*Activity.java". A pattern without a slash matches the file name,
+//any other pattern the path relative to the output root.
+type conflictRule struct {
+	Pattern string
+	Policy  string
+}
+
+func (self conflictRule) matches(path string) bool {
+	return matchesPathPattern(self.Pattern, relativeToRoot(path))
+}
+
+//matchesPathPattern matches a glob from the config or the command line against
+//a path relative to the output root. A pattern without a slash matches the
+//file name alone.
+func matchesPathPattern(pattern string, relativePath string) bool {
+	relativePath = filepath.ToSlash(relativePath)
+	if !strings.Contains(pattern, "/") {
+		relativePath = path.Base(relativePath)
+	}
+	matched, err := path.Match(pattern, relativePath)
+	return err == nil && matched
+}
+
+func validateConflictRules(rules []conflictRule) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return errors.New("Invalid conflict rule pattern " + rule.Pattern + ": " + err.Error())
+		}
+		if !validConflictPolicy(rule.Policy) {
+			return errors.New("Invalid conflict rule policy " + rule.Policy + " for " + rule.Pattern)
+		}
+	}
+	return nil
+}
+
+//conflictPolicyFor returns the policy for an existing file at path. The first
+//matching rule of the config wins, then -quiet, then -on-conflict.
+func conflictPolicyFor(path string) string {
+	for _, rule := range conflictRules {
+		if rule.matches(path) {
+			return rule.Policy
+		}
+	}
+	if forceOverwrite {
+		return CONFLICT_OVERWRITE
+	}
+	return conflictPolicy
+}
+
+//isConflict is true if writing planned would change a file that was not
+//merged with or injected into
+func isConflict(planned plannedFile) bool {
+	return planned.Status == FileOverwritten && !planned.Merged && !planned.Injected
+}
+
+//checkConflictFailures refuses the whole run before anything is written if
+//any conflict falls under the fail policy
+func checkConflictFailures(plan []plannedFile) error {
+	failed := make([]string, 0)
+	for _, planned := range plan {
+		if isConflict(planned) && conflictPolicyFor(planned.Path) == CONFLICT_FAIL {
+			failed = append(failed, planned.Path)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New("Files already exist: " + strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+//conflictResolver decides, file by file, whether the generated contents
+//replace an existing file
+type conflictResolver struct {
+	prompt *overwritePrompt
+}
+
+//resolve returns true if planned should be written to its path. Backups and
+//files written alongside are written here. A stamped file that was edited by
+//hand, or whose user code would be lost, is asked about rather than overwritten.
+func (self *conflictResolver) resolve(planned plannedFile, undo *undoLog) (bool, error) {
+	policy := conflictPolicyFor(planned.Path)
+	if (planned.HandEdited || planned.DropsUserCode) && policy == CONFLICT_OVERWRITE {
+		policy = CONFLICT_ASK
+	}
+	emitEvent(runEvent{Event: EVENT_CONFLICT, Path: planned.Path, Policy: policy})
+	switch policy {
+	case CONFLICT_OVERWRITE:
+		return true, nil
+	case CONFLICT_SKIP:
+		return false, nil
+	case CONFLICT_FAIL:
+		return false, errors.New("File already exists: " + planned.Path)
+	case CONFLICT_BACKUP:
+		if err := writeFile(planned.Path+BACKUP_SUFFIX, planned.Existing, planned.ExistingMode, undo); err != nil {
+			return false, err
+		}
+		return true, nil
+	case CONFLICT_ALONGSIDE:
+		if err := writeFile(planned.Path+ALONGSIDE_SUFFIX, planned.Contents, planned.Mode, undo); err != nil {
+			return false, err
+		}
+		return false, nil
+	}
+	return self.prompt.ask(planned)
+}
+
+func newConflictResolver() *conflictResolver {
+	return &conflictResolver{prompt: newOverwritePrompt(os.Stdin, promptOutput())}
+}
@@ -0,0 +1,203 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConflictRuleMatches(testing *testing.T) {
+	defer cleanup()
+	flag.Set("out", "app")
+	rule := conflictRule{Pattern: "*Activity.java", Policy: CONFLICT_SKIP}
+	if !rule.matches(filepath.Join("app", "src", "CatsActivity.java")) || rule.matches(filepath.Join("app", "src", "Cats.java")) {
+		testing.Errorf("A file name pattern did not match the file name")
+	}
+	rule = conflictRule{Pattern: "src/*.java", Policy: CONFLICT_SKIP}
+	if !rule.matches(filepath.Join("app", "src", "Cats.java")) || rule.matches(filepath.Join("app", "test", "src", "Cats.java")) {
+		testing.Errorf("A path pattern did not match the path relative to the output root")
+	}
+
+	if err := validateConflictRules([]conflictRule{conflictRule{Pattern: "[", Policy: CONFLICT_SKIP}}); err == nil {
+		testing.Errorf("No error for an invalid pattern")
+	}
+	if err := validateConflictRules([]conflictRule{conflictRule{Pattern: "*", Policy: "sometimes"}}); err == nil {
+		testing.Errorf("No error for an invalid policy")
+	}
+}
+
+//listTree lists every file and directory under root, leaving out the state directory
+func listTree(root string) []string {
+	paths := make([]string, 0)
+	filepath.Walk(root, func(path string, fileInfo os.FileInfo, err error) error {
+		if err == nil && fileInfo.IsDir() && fileInfo.Name() == LEVO_STATE_DIRECTORY {
+			return filepath.SkipDir
+		}
+		if err == nil && path != root {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths
+}
+
+func TestConflictPoliciesLeaveTreeUnchanged(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-conflict")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	os.MkdirAll(filepath.Join(outputDir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(outputDir, "src", "Cats.java"), []byte("existing\n"), 0644)
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{Directory: "src/models/..", FileName: "Cats.java", Body: []byte("generated\n")},
+		levo.GeneratedFile{Directory: "src/models", FileName: "Dogs.java", Body: []byte("generated\n")},
+	}
+	before := listTree(outputDir)
+
+	flag.Set("on-conflict", CONFLICT_FAIL)
+	if err := outputFiles(generatedFiles); err == nil {
+		testing.Errorf("No error for an existing file with fail")
+	}
+	if after := listTree(outputDir); !reflect.DeepEqual(before, after) {
+		testing.Errorf("Failing on a conflict changed the tree from %v to %v", before, after)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, LEVO_STATE_DIRECTORY)); !os.IsNotExist(err) {
+		testing.Errorf("Failing on a conflict left a state directory behind")
+	}
+
+	flag.Set("on-conflict", CONFLICT_SKIP)
+	if err := outputFiles(generatedFiles[:1]); err != nil {
+		testing.Fatalf("Error skipping files: %v", err.Error())
+	}
+	if after := listTree(outputDir); !reflect.DeepEqual(before, after) {
+		testing.Errorf("Skipping a file changed the tree from %v to %v", before, after)
+	}
+}
+
+func TestConflictFailKeepsLastRunUndoable(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-conflict")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("on-conflict", CONFLICT_OVERWRITE)
+	catsPath := filepath.Join(outputDir, "Cats.java")
+	ioutil.WriteFile(catsPath, []byte("existing\n"), 0644)
+	generatedFiles := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("generated\n")}}
+
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error overwriting a file: %v", err.Error())
+	}
+	ioutil.WriteFile(catsPath, []byte("edited\n"), 0644)
+	flag.Set("on-conflict", CONFLICT_FAIL)
+	if err := outputFiles(generatedFiles); err == nil {
+		testing.Errorf("No error for an existing file with fail")
+	}
+	ioutil.WriteFile(catsPath, []byte("generated\n"), 0644)
+
+	if err := undoLastRun(); err != nil {
+		testing.Fatalf("Error undoing the run before a failed one: %v", err.Error())
+	}
+	if contents, _ := ioutil.ReadFile(catsPath); string(contents) != "existing\n" {
+		testing.Errorf("Undo after a failed run restored %q, not the file before the last run", contents)
+	}
+}
+
+func TestConflictPolicies(testing *testing.T) {
+	defer cleanup()
+	defer func() { conflictRules = nil }()
+	outputDir := testTempDir(testing, "levo-conflict")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	writeExisting := func() {
+		for _, name := range []string{"Cats.java", "CatsActivity.java", "AbsCats.java"} {
+			ioutil.WriteFile(filepath.Join(outputDir, name), []byte("existing\n"), 0644)
+			os.Remove(filepath.Join(outputDir, name+BACKUP_SUFFIX))
+			os.Remove(filepath.Join(outputDir, name+ALONGSIDE_SUFFIX))
+		}
+	}
+	readFile := func(name string) string {
+		contents, _ := ioutil.ReadFile(filepath.Join(outputDir, name))
+		return string(contents)
+	}
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("generated\n")},
+		levo.GeneratedFile{FileName: "CatsActivity.java", Body: []byte("generated\n")},
+		levo.GeneratedFile{FileName: "AbsCats.java", Body: []byte("generated\n")},
+	}
+
+	writeExisting()
+	flag.Set("on-conflict", CONFLICT_SKIP)
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error skipping files: %v", err.Error())
+	}
+	if readFile("Cats.java") != "existing\n" {
+		testing.Errorf("An existing file was overwritten with skip")
+	}
+
+	flag.Set("on-conflict", CONFLICT_BACKUP)
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error backing up files: %v", err.Error())
+	}
+	if readFile("Cats.java") != "generated\n" || readFile("Cats.java"+BACKUP_SUFFIX) != "existing\n" {
+		testing.Errorf("The existing file was not backed up before overwriting")
+	}
+
+	writeExisting()
+	flag.Set("on-conflict", CONFLICT_ALONGSIDE)
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files alongside: %v", err.Error())
+	}
+	if readFile("Cats.java") != "existing\n" || readFile("Cats.java"+ALONGSIDE_SUFFIX) != "generated\n" {
+		testing.Errorf("The generated file was not written alongside the existing one")
+	}
+
+	//fail writes nothing at all
+	writeExisting()
+	os.Remove(filepath.Join(outputDir, "AbsCats.java"))
+	flag.Set("on-conflict", CONFLICT_FAIL)
+	if err := outputFiles(generatedFiles); err == nil {
+		testing.Errorf("No error for existing files with fail")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "AbsCats.java")); !os.IsNotExist(err) {
+		testing.Errorf("A file was written before failing")
+	}
+
+	//rules from the config take precedence
+	writeExisting()
+	conflictRules = []conflictRule{
+		conflictRule{Pattern: "*Activity.java", Policy: CONFLICT_SKIP},
+		conflictRule{Pattern: "Abs*.java", Policy: CONFLICT_OVERWRITE},
+	}
+	flag.Set("on-conflict", CONFLICT_ALONGSIDE)
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error applying conflict rules: %v", err.Error())
+	}
+	if readFile("CatsActivity.java") != "existing\n" || readFile("CatsActivity.java"+ALONGSIDE_SUFFIX) != "" {
+		testing.Errorf("A file matching a skip rule was written")
+	}
+	if readFile("AbsCats.java") != "generated\n" {
+		testing.Errorf("A file matching an overwrite rule was not overwritten")
+	}
+	if readFile("Cats.java"+ALONGSIDE_SUFFIX) != "generated\n" {
+		testing.Errorf("A file matching no rule did not fall back to -on-conflict")
+	}
+}
@@ -0,0 +1,356 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const DIFF_CONTEXT_LINES int = 3
+
+//diffOp is a single line of an edit script: kept (' '), removed ('-') or added ('+')
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+//splitLines splits contents into lines that keep their "\n" terminator, so
+//that a missing newline at the end of a file survives the diff
+func splitLines(contents []byte) []string {
+	lines := make([]string, 0)
+	text := string(contents)
+	for len(text) > 0 {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 {
+			lines = append(lines, text)
+			break
+		}
+		lines = append(lines, text[:i+1])
+		text = text[i+1:]
+	}
+	return lines
+}
+
+//diffLines returns the shortest edit script that turns a into b. It is
+//Myers' algorithm in linear space: the middle snake of the edit graph splits
+//the problem in two, so that large files do not need a copy of the search
+//state per edit.
+func diffLines(a, b []string) []diffOp {
+	ops := appendDiff(make([]diffOp, 0, len(a)+len(b)), a, b)
+
+	//within a change, removed lines come before the lines that replace them
+	for start := 0; start < len(ops); start++ {
+		if ops[start].Kind == ' ' {
+			continue
+		}
+		end := start
+		for end < len(ops) && ops[end].Kind != ' ' {
+			end++
+		}
+		change := make([]diffOp, 0, end-start)
+		for _, kind := range []byte{'-', '+'} {
+			for _, op := range ops[start:end] {
+				if op.Kind == kind {
+					change = append(change, op)
+				}
+			}
+		}
+		copy(ops[start:end], change)
+		start = end
+	}
+	return ops
+}
+
+func appendDiff(ops []diffOp, a, b []string) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if len(a) == 0 {
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	} else if len(b) == 0 {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+	} else {
+		//both ends differ, so each half takes at least one edit and is smaller
+		x, y, u, v := middleSnake(a, b)
+		ops = appendDiff(ops, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			ops = append(ops, diffOp{' ', line})
+		}
+		ops = appendDiff(ops, a[u:], b[v:])
+	}
+
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+//middleSnake searches the edit graph of a and b from both ends at once, and
+//returns the start and end of the diagonal where the two searches meet. It
+//lies on a shortest edit script, half way through its edits.
+func middleSnake(a, b []string) (int, int, int, int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	offset := max + 1
+	forward := make([]int, 2*max+3)
+	backward := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			//the backward search counts from the end, on diagonal delta-k
+			if c := delta - k; odd && c >= -(d-1) && c <= d-1 && x+backward[offset+c] >= n {
+				return startX, startY, x, y
+			}
+		}
+		for c := -d; c <= d; c += 2 {
+			var x int
+			if c == -d || (c != d && backward[offset+c-1] < backward[offset+c+1]) {
+				x = backward[offset+c+1]
+			} else {
+				x = backward[offset+c-1] + 1
+			}
+			y := x - c
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+c] = x
+			if k := delta - c; !odd && k >= -d && k <= d && forward[offset+k]+x >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+	//not reached: the searches always meet within max edits each
+	return 0, 0, 0, 0
+}
+
+func isBinaryContents(contents []byte) bool {
+	return bytes.IndexByte(contents, 0) >= 0
+}
+
+//gitFileMode is the mode git records for a file with the given permissions
+func gitFileMode(mode os.FileMode) string {
+	if mode&0111 != 0 {
+		return "100755"
+	}
+	return "100644"
+}
+
+//unifiedDiff renders the change from oldContents to newContents as a patch
+//that git apply accepts. A nil oldContents means the file does not exist yet.
+func unifiedDiff(path string, oldContents []byte, newContents []byte, oldMode os.FileMode, newMode os.FileMode) string {
+	path = filepath.ToSlash(path)
+	output := bytes.NewBuffer(nil)
+	fmt.Fprintf(output, "diff --git a/%s b/%s\n", path, path)
+	oldName := "a/" + path
+	if oldContents == nil {
+		fmt.Fprintf(output, "new file mode %s\n", gitFileMode(newMode))
+		oldName = "/dev/null"
+	} else if gitFileMode(oldMode) != gitFileMode(newMode) {
+		fmt.Fprintf(output, "old mode %s\nnew mode %s\n", gitFileMode(oldMode), gitFileMode(newMode))
+		if bytes.Equal(oldContents, newContents) {
+			return output.String()
+		}
+	}
+	if isBinaryContents(oldContents) || isBinaryContents(newContents) {
+		//git apply only takes a binary patch when it can check the blob it applies to
+		fmt.Fprintf(output, "index %s..%s", gitBlobHash(oldContents), gitBlobHash(newContents))
+		if oldContents != nil && gitFileMode(oldMode) == gitFileMode(newMode) {
+			fmt.Fprintf(output, " %s", gitFileMode(newMode))
+		}
+		fmt.Fprintf(output, "\nGIT binary patch\n")
+		writeBinaryLiteral(output, newContents)
+		writeBinaryLiteral(output, oldContents)
+		return output.String()
+	}
+	fmt.Fprintf(output, "--- %s\n+++ b/%s\n", oldName, path)
+
+	ops := diffLines(splitLines(oldContents), splitLines(newContents))
+	writeDiffHunks(output, ops)
+	return output.String()
+}
+
+//gitBlobHash is the object name git gives contents, or all zeros for a file
+//that does not exist
+func gitBlobHash(contents []byte) string {
+	if contents == nil {
+		return strings.Repeat("0", 40)
+	}
+	hash := sha1.New()
+	fmt.Fprintf(hash, "blob %d\x00", len(contents))
+	hash.Write(contents)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+//GIT_BASE85 is the alphabet of the base85 encoding in git binary patches
+const GIT_BASE85 string = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+//writeBinaryLiteral writes contents as one literal hunk of a git binary
+//patch: deflated, then base85 encoded in lines of at most 52 bytes, each
+//starting with its length
+func writeBinaryLiteral(writer io.Writer, contents []byte) {
+	compressed := bytes.NewBuffer(nil)
+	deflater := zlib.NewWriter(compressed)
+	deflater.Write(contents)
+	deflater.Close()
+
+	fmt.Fprintf(writer, "literal %d\n", len(contents))
+	data := compressed.Bytes()
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > 52 {
+			chunk = chunk[:52]
+		}
+		data = data[len(chunk):]
+		if len(chunk) <= 26 {
+			fmt.Fprintf(writer, "%c", 'A'+len(chunk)-1)
+		} else {
+			fmt.Fprintf(writer, "%c", 'a'+len(chunk)-27)
+		}
+		for i := 0; i < len(chunk); i += 4 {
+			var value uint32
+			for j := 0; j < 4; j++ {
+				value <<= 8
+				if i+j < len(chunk) {
+					value |= uint32(chunk[i+j])
+				}
+			}
+			encoded := make([]byte, 5)
+			for j := 4; j >= 0; j-- {
+				encoded[j] = GIT_BASE85[value%85]
+				value /= 85
+			}
+			writer.Write(encoded)
+		}
+		fmt.Fprintf(writer, "\n")
+	}
+	fmt.Fprintf(writer, "\n")
+}
+
+func writeDiffHunks(writer io.Writer, ops []diffOp) {
+	for start := 0; start < len(ops); {
+		//find the next change
+		for start < len(ops) && ops[start].Kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			return
+		}
+
+		//extend the hunk until there are more than twice the context lines unchanged
+		end := start
+		for i := start; i < len(ops); i++ {
+			if ops[i].Kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*DIFF_CONTEXT_LINES {
+				break
+			}
+		}
+
+		hunkStart := start - DIFF_CONTEXT_LINES
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + DIFF_CONTEXT_LINES
+		if hunkEnd > len(ops) {
+			hunkEnd = len(ops)
+		}
+
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.Kind != '+' {
+				oldLine++
+			}
+			if op.Kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		//an empty range is numbered by the line before it
+		if oldCount == 0 {
+			oldLine--
+		}
+		if newCount == 0 {
+			newLine--
+		}
+
+		fmt.Fprintf(writer, "@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount)
+		for _, op := range ops[hunkStart:hunkEnd] {
+			fmt.Fprintf(writer, "%c%s", op.Kind, op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				fmt.Fprintf(writer, "\n\\ No newline at end of file\n")
+			}
+		}
+		start = hunkEnd
+	}
+}
+
+//writeOutputDiff writes one patch covering every planned file that would change the disk
+func writeOutputDiff(plan []plannedFile, writer io.Writer) {
+	for _, planned := range plan {
+		if planned.Status == FileUnchanged {
+			continue
+		}
+		existingContents := planned.Existing
+		if planned.Status == FileCreated {
+			existingContents = nil
+		}
+		fmt.Fprint(writer, unifiedDiff(relativeToRoot(planned.Path), existingContents, planned.Contents, planned.ExistingMode, planned.Mode))
+	}
+}
@@ -0,0 +1,255 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/cfmobile/levolib"
+)
+
+func TestSplitLines(testing *testing.T) {
+	lines := splitLines([]byte("one\ntwo\nthree"))
+	if len(lines) != 3 || lines[0] != "one\n" || lines[2] != "three" {
+		testing.Errorf("Unexpected lines: %q", lines)
+	}
+	if len(splitLines([]byte{})) != 0 {
+		testing.Errorf("Empty contents should have no lines")
+	}
+}
+
+func TestDiffLines(testing *testing.T) {
+	a := splitLines([]byte("a\nb\nc\nd\n"))
+	b := splitLines([]byte("a\nc\nd\ne\n"))
+	ops := diffLines(a, b)
+
+	script := ""
+	for _, op := range ops {
+		script += string(op.Kind) + strings.TrimSpace(op.Line)
+	}
+	if script != " a-b c d+e" {
+		testing.Errorf("Unexpected edit script: %q", script)
+	}
+
+	if len(diffLines([]string{}, []string{})) != 0 {
+		testing.Errorf("Diffing nothing should produce no operations")
+	}
+}
+
+//checkEditScript makes sure ops turns a into b and returns how many edits it takes
+func checkEditScript(testing *testing.T, a, b []string, ops []diffOp) int {
+	oldLines, newLines := make([]string, 0), make([]string, 0)
+	edits := 0
+	for _, op := range ops {
+		if op.Kind != '+' {
+			oldLines = append(oldLines, op.Line)
+		}
+		if op.Kind != '-' {
+			newLines = append(newLines, op.Line)
+		}
+		if op.Kind != ' ' {
+			edits++
+		}
+	}
+	if strings.Join(oldLines, "") != strings.Join(a, "") || strings.Join(newLines, "") != strings.Join(b, "") {
+		testing.Fatalf("Edit script does not turn %q into %q", a, b)
+	}
+	return edits
+}
+
+func TestDiffLinesIsShortest(testing *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, random.Intn(12))
+		for i := range lines {
+			lines[i] = string('a'+rune(random.Intn(3))) + "\n"
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		//the longest common subsequence gives the fewest edits
+		common := make([][]int, len(a)+1)
+		for x := range common {
+			common[x] = make([]int, len(b)+1)
+		}
+		for x := len(a) - 1; x >= 0; x-- {
+			for y := len(b) - 1; y >= 0; y-- {
+				if a[x] == b[y] {
+					common[x][y] = common[x+1][y+1] + 1
+				} else if common[x+1][y] > common[x][y+1] {
+					common[x][y] = common[x+1][y]
+				} else {
+					common[x][y] = common[x][y+1]
+				}
+			}
+		}
+		if edits := checkEditScript(testing, a, b, diffLines(a, b)); edits != len(a)+len(b)-2*common[0][0] {
+			testing.Fatalf("Diffing %q and %q took %d edits instead of %d", a, b, edits, len(a)+len(b)-2*common[0][0])
+		}
+	}
+}
+
+func largeFiles(lines int) ([]string, []string) {
+	a, b := make([]string, lines), make([]string, lines)
+	for i := range a {
+		a[i] = fmt.Sprintf("line %d\n", i)
+		b[i] = fmt.Sprintf("changed %d\n", i)
+		if i%7 == 0 {
+			b[i] = a[i]
+		}
+	}
+	return a, b
+}
+
+func TestDiffLargeFiles(testing *testing.T) {
+	a, b := largeFiles(4000)
+	if edits := checkEditScript(testing, a, b, diffLines(a, b)); edits != 2*(4000-572) {
+		testing.Errorf("Unexpected number of edits: %d", edits)
+	}
+}
+
+func BenchmarkDiffLargeFiles(benchmark *testing.B) {
+	a, b := largeFiles(4000)
+	for i := 0; i < benchmark.N; i++ {
+		diffLines(a, b)
+	}
+}
+
+func TestUnifiedDiff(testing *testing.T) {
+	oldContents := []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n")
+	newContents := []byte("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\nfifteen\n")
+	expecting := "diff --git a/src/Cats.java b/src/Cats.java\n" +
+		"--- a/src/Cats.java\n" +
+		"+++ b/src/Cats.java\n" +
+		"@@ -1,6 +1,6 @@\n 1\n 2\n-3\n+three\n 4\n 5\n 6\n" +
+		"@@ -12,4 +12,4 @@\n 12\n 13\n 14\n-15\n+fifteen\n"
+	output := unifiedDiff("src/Cats.java", oldContents, newContents, 0644, 0644)
+	if output != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, output)
+	}
+
+	//new files are diffed against /dev/null
+	expecting = "diff --git a/Dogs.java b/Dogs.java\nnew file mode 100644\n--- /dev/null\n+++ b/Dogs.java\n@@ -0,0 +1,2 @@\n+one\n+two\n\\ No newline at end of file\n"
+	output = unifiedDiff("Dogs.java", nil, []byte("one\ntwo"), 0, 0644)
+	if output != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, output)
+	}
+
+	output = unifiedDiff("icon.png", []byte{0, 1}, []byte{0, 2}, 0644, 0644)
+	if !strings.Contains(output, "index "+gitBlobHash([]byte{0, 1})+".."+gitBlobHash([]byte{0, 2})+" 100644\nGIT binary patch\nliteral 2\n") {
+		testing.Errorf("Binary files were not diffed as a binary patch:\n%s", output)
+	}
+
+	//mode changes are part of the patch
+	expecting = "diff --git a/gradlew b/gradlew\nold mode 100644\nnew mode 100755\n"
+	output = unifiedDiff("gradlew", []byte("#!/bin/sh\n"), []byte("#!/bin/sh\n"), 0644, 0755)
+	if output != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, output)
+	}
+	output = unifiedDiff("gradlew", nil, []byte("#!/bin/sh\n"), 0, 0755)
+	if !strings.Contains(output, "new file mode 100755\n") {
+		testing.Errorf("New executable file was not marked executable:\n%s", output)
+	}
+}
+
+func TestBinaryDiffApplies(testing *testing.T) {
+	outputDir := testTempDir(testing, "levo-diff")
+	defer os.RemoveAll(outputDir)
+	if _, err := exec.LookPath("git"); err != nil {
+		testing.Skipf("git is not installed (not a code failure)")
+	}
+
+	oldIcon := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	newIcon := bytes.Repeat([]byte("\x89PNG\x00cats and dogs\x00"), 20)
+	ioutil.WriteFile(filepath.Join(outputDir, "icon.png"), oldIcon, 0644)
+	patch := unifiedDiff("icon.png", oldIcon, newIcon, 0644, 0644) +
+		unifiedDiff("res/logo.png", nil, []byte{0, 1, 2, 3, 4, 5, 6, 7}, 0, 0644) +
+		unifiedDiff("README", []byte("cats\n"), []byte("dogs\n"), 0644, 0644)
+	ioutil.WriteFile(filepath.Join(outputDir, "README"), []byte("cats\n"), 0644)
+	ioutil.WriteFile(filepath.Join(outputDir, "levo.patch"), []byte(patch), 0644)
+
+	command := exec.Command("git", "apply", "levo.patch")
+	command.Dir = outputDir
+	if output, err := command.CombinedOutput(); err != nil {
+		testing.Fatalf("git apply rejected the patch: %v\n%s\n%s", err.Error(), output, patch)
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "icon.png")); !bytes.Equal(contents, newIcon) {
+		testing.Errorf("Binary file was not patched: %q", contents)
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "res", "logo.png")); !bytes.Equal(contents, []byte{0, 1, 2, 3, 4, 5, 6, 7}) {
+		testing.Errorf("New binary file was not created: %q", contents)
+	}
+}
+
+func TestWriteOutputDiff(testing *testing.T) {
+	plan := []plannedFile{
+		plannedFile{Path: "Same.java", Contents: []byte("same\n"), Existing: []byte("same\n"), Status: FileUnchanged},
+		plannedFile{Path: "New.java", Contents: []byte("new\n"), Status: FileCreated},
+	}
+	output := bytes.NewBuffer(nil)
+	writeOutputDiff(plan, output)
+	if strings.Contains(output.String(), "Same.java") {
+		testing.Errorf("Unchanged files should not be part of the diff")
+	}
+	if !strings.Contains(output.String(), "+++ b/New.java") {
+		testing.Errorf("New files are missing from the diff:\n%s", output.String())
+	}
+}
+
+func TestWriteOutputDiffUnderOut(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-diff")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	os.MkdirAll(filepath.Join(outputDir, "src"), 0755)
+	ioutil.WriteFile(filepath.Join(outputDir, "src", "Cats.java"), []byte("cats\n"), 0644)
+
+	plan, err := planOutput([]levo.GeneratedFile{
+		levo.GeneratedFile{Directory: "src", FileName: "Cats.java", Body: []byte("dogs\n")},
+		levo.GeneratedFile{Directory: "src", FileName: "Dogs.java", Body: []byte("dogs\n")},
+	})
+	if err != nil {
+		testing.Fatalf("Error planning files: %v", err.Error())
+	}
+	output := bytes.NewBuffer(nil)
+	writeOutputDiff(plan, output)
+	patch := output.String()
+	if !strings.Contains(patch, "diff --git a/src/Cats.java b/src/Cats.java\n") || !strings.Contains(patch, "+++ b/src/Dogs.java\n") {
+		testing.Errorf("Paths in the diff are not relative to the output directory:\n%s", patch)
+	}
+
+	if _, err := exec.LookPath("git"); err != nil {
+		testing.Skipf("git is not installed (not a code failure)")
+	}
+	ioutil.WriteFile(filepath.Join(outputDir, "levo.patch"), []byte(patch), 0644)
+	command := exec.Command("git", "apply", "levo.patch")
+	command.Dir = outputDir
+	if output, err := command.CombinedOutput(); err != nil {
+		testing.Fatalf("git apply rejected the patch: %v\n%s\n%s", err.Error(), output, patch)
+	}
+	if contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "src", "Dogs.java")); string(contents) != "dogs\n" {
+		testing.Errorf("New file was not created by the patch: %q", contents)
+	}
+}
@@ -0,0 +1,127 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+)
+
+//The values of -format
+const FORMAT_TEXT string = "text"
+const FORMAT_JSON string = "json"
+
+//The kinds of event levo reports with -format json
+const (
+	EVENT_START    string = "start"
+	EVENT_TEMPLATE string = "template"
+	EVENT_FILE     string = "file"
+	EVENT_CONFLICT string = "conflict"
+	EVENT_WARNING  string = "warning"
+	EVENT_ERROR    string = "error"
+	EVENT_SUMMARY  string = "summary"
+	EVENT_MESSAGE  string = "message"
+)
+
+//runEvent is one line of the event stream. Only the fields that matter for
+//its kind of event are set.
+type runEvent struct {
+	Event     string
+	Version   string         `json:",omitempty"`
+	Arguments []string       `json:",omitempty"`
+	Template  string         `json:",omitempty"`
+	Path      string         `json:",omitempty"`
+	Status    string         `json:",omitempty"`
+	Size      int            `json:",omitempty"`
+	Policy    string         `json:",omitempty"`
+	Kind      string         `json:",omitempty"`
+	ExitCode  int            `json:",omitempty"`
+	Location  string         `json:",omitempty"`
+	Message   string         `json:",omitempty"`
+	Counts    map[string]int `json:",omitempty"`
+}
+
+//eventOutput receives the events that do not belong to a particular writer
+var eventOutput io.Writer = os.Stdout
+
+//templateLocationRegex finds the template and line in the errors of the Go
+//template package, e.g. "template: _Name_.lt:12: unexpected "}" in operand"
+var templateLocationRegex = regexp.MustCompile(`template: ([^:\s]+):(\d+(?::\d+)?):`)
+
+func validOutputFormat(format string) bool {
+	return format == FORMAT_TEXT || format == FORMAT_JSON
+}
+
+func jsonEvents() bool {
+	return outputFormat == FORMAT_JSON
+}
+
+//reportEvent writes event to writer as a line of JSON with -format json, and
+//text otherwise. Events without text are only reported as JSON.
+func reportEvent(writer io.Writer, event runEvent, text string) {
+	if !jsonEvents() {
+		if text != "" {
+			fmt.Fprintln(writer, text)
+		}
+		return
+	}
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(writer, string(encoded))
+}
+
+func emitEvent(event runEvent) {
+	reportEvent(eventOutput, event, "")
+}
+
+//reportWarning goes to stderr as text, so that it does not mix with output
+//that is piped, but is part of the event stream with -format json
+func reportWarning(message string) {
+	if jsonEvents() {
+		emitEvent(runEvent{Event: EVENT_WARNING, Message: message})
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Warning: "+message)
+}
+
+func reportError(err error) {
+	if !jsonEvents() {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return
+	}
+	kind := UnknownError
+	if levoErr, ok := err.(LevoError); ok {
+		kind = levoErr.Kind
+	}
+	event := runEvent{Event: EVENT_ERROR, Kind: kind.String(), ExitCode: exitCodeForError(err), Message: err.Error()}
+	if match := templateLocationRegex.FindStringSubmatch(err.Error()); match != nil {
+		event.Location = match[1] + ":" + match[2]
+	}
+	emitEvent(event)
+}
+
+//promptOutput is where questions to the user, and the usage, go. With
+//-format json, stdout only carries events.
+func promptOutput() io.Writer {
+	if jsonEvents() {
+		return os.Stderr
+	}
+	return os.Stdout
+}
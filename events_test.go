@@ -0,0 +1,151 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//readEvents decodes a stream of events, failing on any line that is not JSON
+func readEvents(testing *testing.T, output string) []runEvent {
+	events := make([]runEvent, 0)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		event := runEvent{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			testing.Fatalf("Line is not a JSON event: %q", line)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestReportEvent(testing *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	output := bytes.Buffer{}
+	reportEvent(&output, runEvent{Event: EVENT_MESSAGE, Message: "hello"}, "Hello there")
+	if output.String() != "Hello there\n" {
+		testing.Errorf("Unexpected text %q", output.String())
+	}
+
+	flag.Set("format", FORMAT_JSON)
+	output.Reset()
+	reportEvent(&output, runEvent{Event: EVENT_MESSAGE, Message: "hello"}, "Hello there")
+	if output.String() != `{"Event":"message","Message":"hello"}`+"\n" {
+		testing.Errorf("Unexpected event %q", output.String())
+	}
+}
+
+func TestReportError(testing *testing.T) {
+	defer func() { eventOutput = os.Stdout }()
+	defer resetFlags()
+	resetFlags()
+	flag.Set("format", FORMAT_JSON)
+	output := bytes.Buffer{}
+	eventOutput = &output
+
+	reportError(wrapLevoError(TemplateError, "Error generating files: ", newLevoError(TemplateError, `template: _Name_.lt:12: unexpected "}" in operand`)))
+	events := readEvents(testing, output.String())
+	if len(events) != 1 || events[0].Event != EVENT_ERROR || events[0].Kind != "template" || events[0].ExitCode != EXIT_TEMPLATE {
+		testing.Fatalf("Unexpected error event %+v", events)
+	}
+	if events[0].Location != "_Name_.lt:12" {
+		testing.Errorf("Expected the location of the template error. Got %q", events[0].Location)
+	}
+}
+
+func TestUsageErrorEvent(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-usage")
+	defer os.RemoveAll(outputDir)
+	stdout, stderr := os.Stdout, os.Stderr
+	defer func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		eventOutput = os.Stdout
+	}()
+
+	for args, problem := range map[string]string{
+		"-format json -stale sometimes -config levo.json": "-stale must be one of",
+		"-format json -bogus":                             "not defined: -bogus",
+	} {
+		cleanup()
+		setupFlagUsage()
+		os.Stdout, _ = os.Create(filepath.Join(outputDir, "stdout"))
+		os.Stderr, _ = os.Create(filepath.Join(outputDir, "stderr"))
+		eventOutput = os.Stdout
+		code := run(strings.Fields(args))
+		os.Stdout.Close()
+		os.Stderr.Close()
+		os.Stdout, os.Stderr = stdout, stderr
+
+		if code != EXIT_USAGE {
+			testing.Errorf("Expected exit code %v with %v. Got %v", EXIT_USAGE, args, code)
+		}
+		output, _ := ioutil.ReadFile(filepath.Join(outputDir, "stdout"))
+		events := readEvents(testing, string(output))
+		if len(events) != 1 || events[0].Event != EVENT_ERROR || events[0].Kind != UsageError.String() || !strings.Contains(events[0].Message, problem) {
+			testing.Errorf("Expected a single usage error event with %v. Got:\n%s", args, output)
+		}
+		if usage, _ := ioutil.ReadFile(filepath.Join(outputDir, "stderr")); !strings.Contains(string(usage), "levo [options]") {
+			testing.Errorf("Usage was not written to stderr with %v:\n%s", args, usage)
+		}
+	}
+}
+
+func TestOutputFilesEvents(testing *testing.T) {
+	defer func() { eventOutput = os.Stdout }()
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-events")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("quiet", "true")
+	flag.Set("format", FORMAT_JSON)
+	output := bytes.Buffer{}
+	eventOutput = &output
+
+	ioutil.WriteFile(filepath.Join(outputDir, "Dogs.java"), []byte("class Dogs\n"), 0644)
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	events := readEvents(testing, output.String())
+	expected := []string{"file created", "conflict overwrite", "file updated", "summary "}
+	if len(events) != len(expected) {
+		testing.Fatalf("Expected %d events. Got %+v", len(expected), events)
+	}
+	for i, event := range events {
+		if event.Event+" "+event.Status+event.Policy != expected[i] {
+			testing.Errorf("Expected event %v. Got %+v", expected[i], event)
+		}
+	}
+	if events[0].Path != filepath.Join(outputDir, "Cats.java") || events[0].Size != len("class Cats {}\n") {
+		testing.Errorf("File event does not describe the file: %+v", events[0])
+	}
+	if events[3].Counts["created"] != 1 || events[3].Counts["updated"] != 1 {
+		testing.Errorf("Unexpected summary %+v", events[3])
+	}
+}
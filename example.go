@@ -22,11 +22,11 @@ import (
 )
 
 func outputExampleWorkspace() error {
-	fmt.Printf("We are about to create an example workspace. Are you sure? (y/n): ")
+	fmt.Fprintf(promptOutput(), "We are about to create an example workspace. Are you sure? (y/n): ")
 	var b []byte = make([]byte, 1)
 	os.Stdin.Read(b)
 	if string(b) == "n" {
-		return errors.New("User Input: n")
+		return newLevoError(UserDeclinedError, "Example files not created")
 	}
 
 	if err := createExampleDirectory(); err != nil {
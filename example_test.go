@@ -35,8 +35,10 @@ func TestNotOutputExampleWorkspace(testing *testing.T) {
 	p.Args = append(p.Args, "-example")
 	p.Stdin = strings.NewReader(input)
 	output, err := p.CombinedOutput()
-	if err != nil {
-		testing.Error(err)
+	if exitErr, ok := err.(*exec.ExitError); !ok {
+		testing.Errorf("Expected levo to fail when the example is declined")
+	} else if exitErr.ExitCode() != EXIT_USER_DECLINED {
+		testing.Errorf("Expected exit code %v. Got %v", EXIT_USER_DECLINED, exitErr.ExitCode())
 	}
 	fmt.Println(string(output))
 	if strings.Contains(string(output), "Example files not created") == false {
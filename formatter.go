@@ -0,0 +1,145 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//FORMATTER_FILE_PLACEHOLDER stands for the path of the file in a formatter
+//command that cannot read from stdin, such as "gofmt -w {}"
+const FORMATTER_FILE_PLACEHOLDER string = "{}"
+
+//A formatterRule runs Command on every generated file that matches Pattern.
+//Patterns are matched the same way as conflict rules.
+type formatterRule struct {
+	Pattern string
+	Command string
+}
+
+func (self formatterRule) String() string {
+	return self.Pattern + "=" + self.Command
+}
+
+//This type and two methods augment the "-formatter" flag
+type formatterArray []formatterRule
+
+func (self *formatterArray) String() string {
+	return fmt.Sprint(*self)
+}
+func (self *formatterArray) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return errors.New("expected a formatter as <glob>=<command>")
+	}
+	rule := formatterRule{Pattern: strings.TrimSpace(parts[0]), Command: strings.TrimSpace(parts[1])}
+	if err := validateFormatterRules([]formatterRule{rule}); err != nil {
+		return err
+	}
+	*self = append(*self, rule)
+	return nil
+}
+
+func validateFormatterRules(rules []formatterRule) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return errors.New("Invalid formatter pattern '" + rule.Pattern + "'")
+		}
+		if len(strings.Fields(rule.Command)) == 0 {
+			return errors.New("Formatter for '" + rule.Pattern + "' has no command")
+		}
+	}
+	return nil
+}
+
+//formatterFor returns the first formatter that matches relativePath. Formatters
+//given on the command line come before the ones in the config.
+func formatterFor(relativePath string) (formatterRule, bool) {
+	for _, rules := range [][]formatterRule{formatters, configFormatters} {
+		for _, rule := range rules {
+			if matchesPathPattern(rule.Pattern, relativePath) {
+				return rule, true
+			}
+		}
+	}
+	return formatterRule{}, false
+}
+
+//formatFileContents runs the formatter for relativePath, if there is one, on
+//contents. Binary files are never formatted.
+func formatFileContents(relativePath string, contents []byte) ([]byte, error) {
+	rule, found := formatterFor(relativePath)
+	if !found || isBinaryContents(contents) {
+		return contents, nil
+	}
+	formatted, err := rule.run(relativePath, contents)
+	if err != nil {
+		return []byte{}, errors.New("Formatter '" + rule.Command + "' failed: " + err.Error())
+	}
+	return formatted, nil
+}
+
+//run pipes contents through the command, or, if the command names the file
+//with {}, formats a temporary copy of it in place. The copy keeps the name of
+//the generated file, since formatters pick the language from the extension.
+//The command is not run through a shell.
+func (self formatterRule) run(relativePath string, contents []byte) ([]byte, error) {
+	args := strings.Fields(self.Command)
+	tempPath := ""
+	for i, arg := range args {
+		if !strings.Contains(arg, FORMATTER_FILE_PLACEHOLDER) {
+			continue
+		}
+		if tempPath == "" {
+			tempDirectory, err := ioutil.TempDir("", "levo-format")
+			if err != nil {
+				return []byte{}, err
+			}
+			defer os.RemoveAll(tempDirectory)
+			tempPath = filepath.Join(tempDirectory, filepath.Base(relativePath))
+			if err := ioutil.WriteFile(tempPath, contents, 0644); err != nil {
+				return []byte{}, err
+			}
+		}
+		args[i] = strings.Replace(arg, FORMATTER_FILE_PLACEHOLDER, tempPath, -1)
+	}
+
+	command := exec.Command(args[0], args[1:]...)
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+	if tempPath == "" {
+		command.Stdin = bytes.NewReader(contents)
+	}
+	if err := command.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return []byte{}, errors.New(err.Error() + ": " + message)
+		}
+		return []byte{}, err
+	}
+	if tempPath != "" {
+		return ioutil.ReadFile(tempPath)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,101 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFormatterFlag(testing *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	if err := flag.Set("formatter", "*.go=gofmt -s"); err != nil {
+		testing.Fatalf("Valid formatter was rejected: %v", err)
+	}
+	if rule, found := formatterFor(filepath.Join("src", "cats.go")); !found || rule.Command != "gofmt -s" {
+		testing.Errorf("Formatter was not matched against a file name: %v", rule)
+	}
+	if _, found := formatterFor("Cats.java"); found {
+		testing.Errorf("Formatter was matched against another extension")
+	}
+	if err := flag.Set("formatter", "*.go"); err == nil {
+		testing.Errorf("No error for a formatter without a command")
+	}
+	if err := flag.Set("formatter", "[=gofmt"); err == nil {
+		testing.Errorf("No error for a formatter with a broken pattern")
+	}
+}
+
+func TestFormatFileContents(testing *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	flag.Set("formatter", "*.txt=tr a-z A-Z")
+	flag.Set("formatter", "*.cfg=sed -i s/cats/dogs/ {}")
+	flag.Set("formatter", "*.bad=false")
+
+	contents, err := formatFileContents("notes.txt", []byte("cats\n"))
+	if err != nil || string(contents) != "CATS\n" {
+		testing.Errorf("File was not piped through the formatter: %q %v", contents, err)
+	}
+	contents, err = formatFileContents("app.cfg", []byte("cats\n"))
+	if err != nil || string(contents) != "dogs\n" {
+		testing.Errorf("File was not formatted in place: %q %v", contents, err)
+	}
+	contents, err = formatFileContents("Cats.java", []byte("cats\n"))
+	if err != nil || string(contents) != "cats\n" {
+		testing.Errorf("File without a formatter was changed: %q %v", contents, err)
+	}
+	contents, err = formatFileContents("icon.txt", []byte{0x89, 0x00, 'c'})
+	if err != nil || len(contents) != 3 || contents[2] != 'c' {
+		testing.Errorf("Binary file was formatted: %q %v", contents, err)
+	}
+	if _, err := formatFileContents("cats.bad", []byte("cats\n")); err == nil {
+		testing.Errorf("No error when the formatter fails")
+	}
+}
+
+func TestOutputFilesFormatsBeforeComparing(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-format")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("formatter", "*.java=tr a-z A-Z")
+
+	generatedFiles := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class cats {}\n")}}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	path := filepath.Join(outputDir, "Cats.java")
+	contents, _ := ioutil.ReadFile(path)
+	if string(contents) != "CLASS CATS {}\n" {
+		testing.Errorf("Formatted file was not written: %q", contents)
+	}
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour).Truncate(time.Second)
+	os.Chtimes(path, lastWeek, lastWeek)
+
+	//the formatted file is unchanged, so this does not prompt
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Formatted file was treated as a conflict: %v", err.Error())
+	}
+	if fileInfo, _ := os.Stat(path); !fileInfo.ModTime().Equal(lastWeek) {
+		testing.Errorf("Formatted file was rewritten")
+	}
+}
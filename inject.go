@@ -0,0 +1,81 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+//A generated file whose body starts with an inject header is not written as a
+//whole. Instead, the rest of the body is inserted into the existing file after
+//(or before) the first line matching the anchor regular expression, e.g.
+//
+//	<<levo filename:Routes.java directory:src>>
+//	<<levoinject after:// levo routes>>
+//	routes.add({{.Name}}.class);
+//	<<levo>>
+var injectHeaderRegex = regexp.MustCompile(`^<<levoinject (after|before):(.*)>>\r?\n?`)
+
+type snippetInjection struct {
+	Before  bool
+	Anchor  *regexp.Regexp
+	Snippet string
+}
+
+//parseSnippetInjection returns false if body is a regular file rather than a snippet
+func parseSnippetInjection(body []byte) (snippetInjection, bool, error) {
+	match := injectHeaderRegex.FindSubmatch(body)
+	if match == nil {
+		return snippetInjection{}, false, nil
+	}
+	anchor, err := regexp.Compile(string(match[2]))
+	if err != nil {
+		return snippetInjection{}, true, errors.New("Invalid inject anchor: " + err.Error())
+	}
+	snippet := string(body[len(match[0]):])
+	if snippet != "" && !strings.HasSuffix(snippet, "\n") {
+		snippet += "\n"
+	}
+	return snippetInjection{Before: string(match[1]) == "before", Anchor: anchor, Snippet: snippet}, true, nil
+}
+
+//apply inserts the snippet into contents. A snippet that is already present is
+//not inserted again, so running levo twice does not duplicate it.
+func (self snippetInjection) apply(contents []byte) ([]byte, error) {
+	if strings.Contains(string(contents), strings.TrimRight(self.Snippet, "\n")) {
+		return contents, nil
+	}
+
+	lines := splitLines(contents)
+	for i, line := range lines {
+		if !self.Anchor.MatchString(strings.TrimRight(line, "\r\n")) {
+			continue
+		}
+		insertAt := i
+		if !self.Before {
+			insertAt = i + 1
+			if !strings.HasSuffix(line, "\n") {
+				lines[i] += "\n"
+			}
+		}
+		injected := append([]string{}, lines[:insertAt]...)
+		injected = append(injected, self.Snippet)
+		injected = append(injected, lines[insertAt:]...)
+		return []byte(strings.Join(injected, "")), nil
+	}
+	return []byte{}, errors.New("No line matches the inject anchor " + self.Anchor.String())
+}
@@ -0,0 +1,115 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSnippetInjection(testing *testing.T) {
+	_, isInjection, err := parseSnippetInjection([]byte("package com.test;\n"))
+	if isInjection || err != nil {
+		testing.Errorf("A regular file was parsed as a snippet")
+	}
+
+	injection, isInjection, err := parseSnippetInjection([]byte("<<levoinject before:^\\s*</application>>>\n<activity android:name=\".CatsActivity\"/>"))
+	if !isInjection || err != nil {
+		testing.Fatalf("Snippet was not parsed: %v", err)
+	}
+	if !injection.Before || injection.Anchor.String() != "^\\s*</application>" {
+		testing.Errorf("Unexpected injection: %v %v", injection.Before, injection.Anchor.String())
+	}
+	if injection.Snippet != "<activity android:name=\".CatsActivity\"/>\n" {
+		testing.Errorf("Unexpected snippet: %q", injection.Snippet)
+	}
+
+	_, _, err = parseSnippetInjection([]byte("<<levoinject after:([>>\nsnippet"))
+	if err == nil {
+		testing.Errorf("No error for an invalid anchor")
+	}
+}
+
+func TestSnippetInjectionApply(testing *testing.T) {
+	routes := "routes {\n  // levo routes\n}\n"
+	injection, _, _ := parseSnippetInjection([]byte("<<levoinject after:// levo routes>>\n  add(Cats)\n"))
+
+	injected, err := injection.apply([]byte(routes))
+	expecting := "routes {\n  // levo routes\n  add(Cats)\n}\n"
+	if err != nil || string(injected) != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, string(injected))
+	}
+
+	//injecting again changes nothing
+	again, err := injection.apply(injected)
+	if err != nil || string(again) != expecting {
+		testing.Errorf("Snippet was injected twice:\n%s", string(again))
+	}
+
+	_, err = injection.apply([]byte("no anchor here\n"))
+	if err == nil {
+		testing.Errorf("No error when the anchor is missing")
+	}
+}
+
+func TestPlanInjection(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-inject")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	ioutil.WriteFile(filepath.Join(outputDir, "Routes.java"), []byte("// routes\n"), 0644)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("<<levoinject after:// routes>>\nadd(Cats);\n")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("<<levoinject after:// routes>>\nadd(Dogs);\n")},
+		levo.GeneratedFile{FileName: "Module.java", Body: []byte("// models\n")},
+		levo.GeneratedFile{FileName: "Module.java", Body: []byte("<<levoinject after:// models>>\nbind(Cats);\n")},
+	}
+	plan, err := planOutput(generatedFiles)
+	if err != nil {
+		testing.Fatalf("Error when planning valid injections: %v", err.Error())
+	}
+	if len(plan) != 2 {
+		testing.Fatalf("Expected injections to be folded into 2 files. Got %v", len(plan))
+	}
+	if string(plan[0].Contents) != "// routes\nadd(Dogs);\nadd(Cats);\n" || plan[0].Status != FileOverwritten || !plan[0].Injected {
+		testing.Errorf("Unexpected injection into an existing file: %q %v", string(plan[0].Contents), plan[0].Status)
+	}
+	if string(plan[1].Contents) != "// models\nbind(Cats);\n" || plan[1].Status != FileCreated {
+		testing.Errorf("Unexpected injection into a generated file: %q %v", string(plan[1].Contents), plan[1].Status)
+	}
+
+	//a snippet comes before the file it is injected into when its mapping comes first
+	generatedFiles = []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("<<levoinject after:// routes>>\nadd(Cats);\n")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("// routes\nadd(Dogs);\n")},
+	}
+	plan, err = planOutput(generatedFiles)
+	if err != nil {
+		testing.Fatalf("Error when planning an injection ordered first: %v", err.Error())
+	}
+	if len(plan) != 1 || string(plan[0].Contents) != "// routes\nadd(Cats);\nadd(Dogs);\n" || plan[0].Injected {
+		testing.Errorf("Snippet ordered first was not injected into the generated file: %+v", plan)
+	}
+
+	missing := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Missing.java", Body: []byte("<<levoinject after:x>>\ny\n")}}
+	if _, err := planOutput(missing); err == nil {
+		testing.Errorf("No error when injecting into a file that does not exist")
+	}
+}
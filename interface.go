@@ -18,7 +18,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"os"
+	"io/ioutil"
 	"strings"
 )
 
@@ -83,11 +83,34 @@ var projectName string
 var templateFeatures templateFeatureArray
 var getTemplateFeatures bool
 var getVersion bool
+var dryRun bool
+var showDiff bool
+var mergeRegeneration bool
+var cleanOutput bool
+var undoRun bool
+var stalePolicy string
+var verifyOnly bool
+var outputDirectory string
+var allowEscape bool
+var archivePath string
+var transactional bool
+var conflictPolicy string
+var conflictRules []conflictRule
+var formatters formatterArray
+var stampOutput bool
+var stdoutOutput bool
+var outputFormat string
+var configFormatters []formatterRule
+var unknownCommands []string
+var usageProblem string
 
 func setupFlags() {
 	fmt.Printf("")
 	modelNames = make(nameArray, 0)
 	model = make(modelArray, 0)
+	formatters = make(formatterArray, 0)
+	unknownCommands = make([]string, 0)
+	usageProblem = ""
 	flag.StringVar(&configPath, "config", "", "The full path to your configuration file")
 	flag.StringVar(&configPath, "c", "", "")
 	flag.StringVar(&projectName, "project", "", "The string to use wherever a template requires the name of the project")
@@ -104,48 +127,86 @@ func setupFlags() {
 	flag.StringVar(&schemaPath, "s", "", "")
 	flag.StringVar(&templatePath, "template", "", "The full path to the template")
 	flag.StringVar(&templatePath, "t", "", "")
+	flag.StringVar(&outputDirectory, "out", "", "The directory generated files are written under. Defaults to the OutputDirectory in the config, or the current directory")
+	flag.StringVar(&outputDirectory, "o", "", "")
+	flag.BoolVar(&stdoutOutput, "stdout", false, "When set, the commandline tool will write the generated files to stdout instead of to disk: a single file as is, several files each behind a '==> path <==' line. The same as -out -")
+	flag.BoolVar(&allowEscape, "allow-escape", false, "When set, templates may generate files outside of the output directory, through absolute paths or '..'. Only use this with templates you trust")
 	flag.BoolVar(&getTemplateFeatures, "list", false, "When this parameter is used in conjunction with the -template parameter, levo will describe the optional configuration flags specific to that set of templates")
 	flag.Var(&templateFeatures, "features", "This commandline parameter is provided for [un]setting the optional features specific to a set of templates. Keywords 'all' and 'none' work as expected. Prepending '-' or '+' indicates that the feature will be unset or set respectively.")
 	flag.Var(&templateFeatures, "f", "")
 	flag.BoolVar(&zipOutput, "zip", false, "When set, the commandline tool will output a zip file instead of numerous source code files")
 	flag.BoolVar(&zipOutput, "z", false, "")
+	flag.StringVar(&archivePath, "archive", "", "When set, the commandline tool will write the generated files to this archive instead. The format is picked from the extension: .zip, .tar, .tar.gz or .tgz. Use - to write a zip to stdout")
 	flag.BoolVar(&forceOverwrite, "quiet", false, "When set, the commandline tool will overwrite generated files without asking")
 	flag.BoolVar(&forceOverwrite, "q", false, "")
-	flag.BoolVar(&alwaysAsk, "ask", false, "When set, the commandline tool will ask for before overwriting every file. If not set, the tool will ask once and use that answer for all subsequent overwrites")
+	flag.BoolVar(&alwaysAsk, "ask", false, "The commandline tool asks before overwriting every existing file, unless told otherwise by answering 'a' or 'd' at the prompt. Kept for compatibility, since this is the default")
 	flag.BoolVar(&alwaysAsk, "a", false, "")
+	flag.StringVar(&conflictPolicy, "on-conflict", CONFLICT_ASK, "What to do when a generated file already exists: ask, overwrite, skip, fail (before writing anything), backup (keep the existing file as .orig) or alongside (write the generated file as .new). ConflictRules in the config take precedence")
+	flag.Var(&formatters, "formatter", "A formatter command that is run on every generated file matching a glob, with the format glob=command. eg. \"*.go=gofmt\". The file is piped through the command, unless the command names it with {}. Can be given more than once, and takes precedence over the Formatters in the config")
+	flag.BoolVar(&stampOutput, "stamp", false, "When set, every generated source file starts with a comment naming the levo version, template and schema it was generated from, and a checksum. A stamped file that was edited by hand is never overwritten without asking")
+	flag.StringVar(&outputFormat, "format", FORMAT_TEXT, "How levo reports what it does: text, or json for a stream of events, one JSON object per line, for IDEs and CI")
+	flag.BoolVar(&verifyOnly, "verify", false, "When set, the commandline tool will write nothing and instead fail with a diff if any generated file on disk differs from what levo would generate now. Can also be given as the command 'levo verify'")
+	flag.BoolVar(&transactional, "transaction", false, "When set, a run that fails or is interrupted restores every file it touched, so that the files are either all generated or left untouched")
+	flag.BoolVar(&dryRun, "dry-run", false, "When set, the commandline tool will list every file it would generate, with its size and whether it would be created, overwritten or left unchanged, without writing anything")
+	flag.BoolVar(&mergeRegeneration, "merge", false, "When set, files levo generated before are merged three ways with the changes made to them since, instead of asking to overwrite them. Conflicting changes are written between conflict markers")
+	flag.BoolVar(&showDiff, "diff", false, "When set, the commandline tool will print a unified diff between the files on disk and the generated files instead of writing them. The output can be saved and applied with 'git apply'")
+	flag.StringVar(&stalePolicy, "stale", STALE_REPORT, "What to do with files an earlier run generated that are no longer generated, e.g. because their model was removed from the schema. One of report, delete or ignore. Files changed since they were generated are only deleted with -quiet")
 	flag.BoolVar(&getVersion, "version", false, "Setting this flag will output Levo's version information")
 	flag.BoolVar(&getVersion, "v", false, "")
+	flag.BoolVar(&cleanOutput, "clean", false, "Removes every file the last run of levo generated here. Files changed since they were generated are kept unless -quiet is also set. Can also be given as the command 'levo clean'")
+	flag.BoolVar(&undoRun, "undo", false, "Restores every file touched by the last run of levo to the state it was in before that run. Can also be given as the command 'levo undo'")
 	flag.BoolVar(&example, "example", false, "This flag will cause other flags to be ignored and will produce a directory that contains all of the files needed to form an example workspace")
 }
 
 func setupFlagUsage() {
 	flag.Usage = func() {
-		fmt.Println("levo [options] -config <file_path>")
-		fmt.Println("levo [options] -model <model_json> [-model <model_json>] -template <file_path>")
-		fmt.Println("levo [options] (-name <model_name> | -names <<model_name>,...>) -schema <file_path> -template <file_path>")
-		fmt.Println("levo -template <file_path> -list")
-		fmt.Println("levo -example")
+		output := promptOutput()
+		fmt.Fprintln(output, "levo [options] -config <file_path>")
+		fmt.Fprintln(output, "levo [options] -model <model_json> [-model <model_json>] -template <file_path>")
+		fmt.Fprintln(output, "levo [options] (-name <model_name> | -names <<model_name>,...>) -schema <file_path> -template <file_path>")
+		fmt.Fprintln(output, "levo -template <file_path> -list")
+		fmt.Fprintln(output, "levo verify [options] -config <file_path>")
+		fmt.Fprintln(output, "levo (-clean | -undo)")
+		fmt.Fprintln(output, "levo -example")
 
-		fmt.Println("\nArguments")
-		fmt.Printf(printFlagUsage(flag.Lookup("config"), flag.Lookup("c"), "<file_path>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("name"), flag.Lookup("n"), "<model_name>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("names"), flag.Lookup("N"), "<model_name,...>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("model"), flag.Lookup("m"), "<model_def>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("schema"), flag.Lookup("s"), "<file_path>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("template"), flag.Lookup("t"), "<file_path>"))
+		fmt.Fprintln(output, "\nArguments")
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("config"), flag.Lookup("c"), "<file_path>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("name"), flag.Lookup("n"), "<model_name>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("names"), flag.Lookup("N"), "<model_name,...>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("model"), flag.Lookup("m"), "<model_def>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("schema"), flag.Lookup("s"), "<file_path>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("template"), flag.Lookup("t"), "<file_path>"))
 
-		fmt.Println("\nOptions")
-		fmt.Printf(printFlagUsage(flag.Lookup("list"), flag.Lookup(""), ""))
-		fmt.Printf(printFlagUsage(flag.Lookup("features"), flag.Lookup("f"), "all,none,[+|-]<template_features>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("zip"), flag.Lookup("z"), ""))
-		fmt.Printf(printFlagUsage(flag.Lookup("quiet"), flag.Lookup("q"), ""))
-		fmt.Printf(printFlagUsage(flag.Lookup("ask"), flag.Lookup("a"), ""))
-		fmt.Printf(printFlagUsage(flag.Lookup("version"), flag.Lookup("v"), ""))
-		fmt.Printf(printFlagUsage(flag.Lookup("project"), flag.Lookup("p"), "<project_name>"))
-		fmt.Printf(printFlagUsage(flag.Lookup("package"), flag.Lookup("k"), "<package>"))
+		fmt.Fprintln(output, "\nOptions")
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("list"), flag.Lookup(""), ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("features"), flag.Lookup("f"), "all,none,[+|-]<template_features>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("out"), flag.Lookup("o"), "<directory>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("stdout"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("allow-escape"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("zip"), flag.Lookup("z"), ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("archive"), nil, "<file_path>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("quiet"), flag.Lookup("q"), ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("ask"), flag.Lookup("a"), ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("on-conflict"), nil, "<policy>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("transaction"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("formatter"), nil, "<glob>=<command>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("stamp"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("merge"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("stale"), nil, "report|delete|ignore"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("dry-run"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("diff"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("verify"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("format"), nil, "text|json"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("version"), flag.Lookup("v"), ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("project"), flag.Lookup("p"), "<project_name>"))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("package"), flag.Lookup("k"), "<package>"))
 
-		fmt.Println("\nExample")
-		fmt.Printf(printFlagUsage(flag.Lookup("example"), nil, ""))
+		fmt.Fprintln(output, "\nCommands")
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("clean"), nil, ""))
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("undo"), nil, ""))
+
+		fmt.Fprintln(output, "\nExample")
+		fmt.Fprint(output, printFlagUsage(flag.Lookup("example"), nil, ""))
 	}
 }
 
@@ -181,46 +242,99 @@ func printFlagUsage(mainFlag *flag.Flag, shortFlag *flag.Flag, inputType string)
 	return output
 }
 
-func parseFlags() {
-	flag.Parse()
+//parseFlags parses the arguments that follow the name of the tool. Commands
+//may also be given without a leading dash, e.g. 'levo verify -config x.json'.
+//Parsing flags stops at the first word that is not one, so a command that
+//comes first is taken off before the flags are parsed.
+func parseFlags(args []string) error {
+	unknownCommands = make([]string, 0)
+	if len(args) > 0 && setCommand(args[0]) {
+		args = args[1:]
+	}
+	//errors of the flag set are reported like the others, as an event with -format json
+	flag.CommandLine.SetOutput(ioutil.Discard)
+	flag.CommandLine.Usage = func() {}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		rejectFlags(err.Error())
+		return err
+	}
+	for _, command := range flag.Args() {
+		if !setCommand(command) {
+			unknownCommands = append(unknownCommands, command)
+		}
+	}
+	return nil
+}
+
+//setCommand sets the flag of a command given as a word, and returns false if
+//command is not one
+func setCommand(command string) bool {
+	switch command {
+	case "clean":
+		cleanOutput = true
+	case "undo":
+		undoRun = true
+	case "verify":
+		verifyOnly = true
+	default:
+		return false
+	}
+	return true
+}
+
+//rejectFlags remembers why the flags cannot be used and shows the usage. It
+//always returns false.
+func rejectFlags(problem string) bool {
+	usageProblem = problem
+	flag.Usage()
+	return false
+}
+
+//usageMessage is the error levo reports when the flags cannot be used
+func usageMessage() string {
+	if usageProblem == "" {
+		return "Invalid arguments"
+	}
+	return "Invalid arguments: " + usageProblem
 }
 
 func checkFlags() bool {
-	if getVersion {
+	if len(unknownCommands) > 0 {
+		return rejectFlags("Unknown command " + unknownCommands[0])
+	} else if cleanOutput && undoRun {
+		return rejectFlags("-clean and -undo are mutually exclusive")
+	} else if getVersion {
 		return true
-	} else if example {
+	} else if example || cleanOutput || undoRun {
 		return true
 	} else if configPath != "" && !configFlagGood() {
-		fmt.Fprintf(os.Stderr, "When using -config, do not also use -model, -name, -names, -schema, or -template\n")
-		flag.Usage()
-		return false
+		return rejectFlags("When using -config, do not also use -model, -name, -names, -schema, or -template")
 	} else if len(model) > 0 && !modelFlagGood() {
-		fmt.Fprintf(os.Stderr, "When using -model, -template must also be used\n")
-		flag.Usage()
-		return false
+		return rejectFlags("When using -model, -template must also be used")
 	} else if modelName != "" && !modelNameFlagGood() {
-		fmt.Fprintf(os.Stderr, "When using -name, both -template and -schema must also be used\n")
-		flag.Usage()
-		return false
+		return rejectFlags("When using -name, both -template and -schema must also be used")
 	} else if len(modelNames) > 0 && !modelNamesFlagGood() {
-		fmt.Fprintf(os.Stderr, "When using -names, both -template and -schema must also be used\n")
-		flag.Usage()
-		return false
+		return rejectFlags("When using -names, both -template and -schema must also be used")
 	} else if schemaPath != "" && !schemaFlagGood() {
-		fmt.Fprintf(os.Stderr, "When using -schema, -template and one of -name or -names must also be used\n")
-		flag.Usage()
-		return false
+		return rejectFlags("When using -schema, -template and one of -name or -names must also be used")
+	} else if !validStalePolicy(stalePolicy) {
+		return rejectFlags("-stale must be one of report, delete or ignore")
+	} else if !validConflictPolicy(conflictPolicy) {
+		return rejectFlags("-on-conflict must be one of ask, overwrite, skip, fail, backup or alongside")
+	} else if forceOverwrite && conflictPolicy != CONFLICT_ASK {
+		return rejectFlags("-quiet and -on-conflict are mutually exclusive")
 	} else if forceOverwrite && alwaysAsk {
-		fmt.Fprintf(os.Stderr, "-force and -ask are mutually exclusive\n")
-		flag.Usage()
-		return false
+		return rejectFlags("-force and -ask are mutually exclusive")
+	} else if writesToStdout() && (zipOutput || archivePath != "") {
+		return rejectFlags("-stdout cannot be used with -zip or -archive")
+	} else if !validOutputFormat(outputFormat) {
+		return rejectFlags("-format must be one of text or json")
+	} else if jsonEvents() && (writesToStdout() || showDiff || archivePath == ARCHIVE_STDOUT) {
+		return rejectFlags("-format json cannot be used with -stdout, -diff or -archive -, which write to stdout as well")
 	} else if configPath == "" && len(model) <= 0 && modelName == "" && len(modelNames) <= 0 && templatePath == "" && !example {
-		flag.Usage()
-		return false
+		return rejectFlags("")
 	} else if getTemplateFeatures && templatePath == "" {
-		fmt.Fprintf(os.Stderr, "-list must be used in conjunction with -template\n")
-		flag.Usage()
-		return false
+		return rejectFlags("-list must be used in conjunction with -template")
 	}
 	return true
 }
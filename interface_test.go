@@ -72,6 +72,48 @@ func TestPrintFlagUsage(testing *testing.T) {
 	}
 }
 
+func TestParseFlagsVerify(testing *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	parseFlags([]string{"verify", "-config", "x"})
+	if !verifyOnly || configPath != "x" || len(unknownCommands) != 0 {
+		testing.Errorf("'levo verify -config x' was not parsed: verify %v, config %q, unknown %v", verifyOnly, configPath, unknownCommands)
+	}
+
+	resetFlags()
+	parseFlags([]string{"-config", "x", "verify"})
+	if !verifyOnly || configPath != "x" || len(unknownCommands) != 0 {
+		testing.Errorf("'levo -config x verify' was not parsed: verify %v, config %q, unknown %v", verifyOnly, configPath, unknownCommands)
+	}
+
+	resetFlags()
+	parseFlags([]string{"-config", "x", "bogus"})
+	if len(unknownCommands) != 1 || unknownCommands[0] != "bogus" {
+		testing.Errorf("Unknown command was not reported: %v", unknownCommands)
+	}
+}
+
+func TestParseFlagsCleanAndUndo(testing *testing.T) {
+	defer resetFlags()
+	resetFlags()
+	parseFlags([]string{"clean", "-quiet"})
+	if !cleanOutput || !forceOverwrite || len(unknownCommands) != 0 {
+		testing.Errorf("'levo clean -quiet' was not parsed: clean %v, quiet %v, unknown %v", cleanOutput, forceOverwrite, unknownCommands)
+	}
+	if !checkFlags() {
+		testing.Errorf("'levo clean -quiet' was rejected")
+	}
+
+	resetFlags()
+	parseFlags([]string{"undo", "-out", "dir"})
+	if !undoRun || outputDirectory != "dir" || len(unknownCommands) != 0 {
+		testing.Errorf("'levo undo -out dir' was not parsed: undo %v, out %q, unknown %v", undoRun, outputDirectory, unknownCommands)
+	}
+	if !checkFlags() {
+		testing.Errorf("'levo undo -out dir' was rejected")
+	}
+}
+
 func TestCheckFlags(testing *testing.T) {
 	defer resetFlags()
 	resetFlags()
@@ -195,4 +237,30 @@ func TestCheckFlags(testing *testing.T) {
 	if ok {
 		testing.Errorf("Should have thrown error for force and ask")
 	}
+
+	resetFlags()
+	flag.Set("template", "path/to/template")
+	flag.Set("stdout", "true")
+	flag.Set("zip", "true")
+	ok = checkFlags()
+	if ok {
+		testing.Errorf("Should have thrown error for stdout and zip")
+	}
+
+	resetFlags()
+	flag.Set("template", "path/to/template")
+	flag.Set("format", "xml")
+	ok = checkFlags()
+	if ok {
+		testing.Errorf("Should have thrown error for an unknown format")
+	}
+
+	resetFlags()
+	flag.Set("template", "path/to/template")
+	flag.Set("format", "json")
+	flag.Set("diff", "true")
+	ok = checkFlags()
+	if ok {
+		testing.Errorf("Should have thrown error for json and diff")
+	}
 }
@@ -32,6 +32,13 @@ type JSONConfigAdapter struct {
 	Language            string
 	TemplateFeatures    []string
 	Zip                 bool
+	OutputDirectory     string
+	Archive             string
+	ConflictRules       []conflictRule
+	Formatters          []formatterRule
+	Stamp               bool
+	Normalization       outputNormalization
+	NormalizationRules  []outputNormalization
 }
 
 type modelToTemplateMapping struct {
@@ -169,6 +176,14 @@ func (self *JSONConfigAdapter) validate() error {
 		return errors.New("Configuration did not define a Language")
 	} else if self.TemplaterVersion == "" {
 		return errors.New("Configuration did not define a Templater Version")
+	} else if err := validateConflictRules(self.ConflictRules); err != nil {
+		return err
+	} else if err := validateFormatterRules(self.Formatters); err != nil {
+		return err
+	} else if err := validateNormalization(self.Normalization); err != nil {
+		return err
+	} else if err := validateNormalizationRules(self.NormalizationRules); err != nil {
+		return err
 	}
 	//TODO fill this out more
 	return nil
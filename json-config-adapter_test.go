@@ -162,6 +162,42 @@ func TestParseConfigurationString(testing *testing.T) {
 	if err == nil {
 		testing.Errorf("ParseConfigurationString did not fail when passed incorrect JSON")
 	}
+
+	//Test conflict rules
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "ConflictRules": [{"Pattern": "*Activity.java", "Policy": "skip"}]}`))
+	if err != nil || len(configAdapter.ConflictRules) != 1 || configAdapter.ConflictRules[0].Policy != CONFLICT_SKIP {
+		testing.Errorf("Conflict rules were not parsed: %v", err)
+	}
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "ConflictRules": [{"Pattern": "*", "Policy": "sometimes"}]}`))
+	if err == nil {
+		testing.Errorf("ParseConfigurationString did not fail when passed an invalid conflict policy")
+	}
+
+	//Test formatters
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "Formatters": [{"Pattern": "*.java", "Command": "google-java-format -"}]}`))
+	if err != nil || len(configAdapter.Formatters) != 1 || configAdapter.Formatters[0].Command != "google-java-format -" {
+		testing.Errorf("Formatters were not parsed: %v", err)
+	}
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "Formatters": [{"Pattern": "*.java", "Command": ""}]}`))
+	if err == nil {
+		testing.Errorf("ParseConfigurationString did not fail when passed a formatter without a command")
+	}
+
+	//Test normalization
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "Normalization": {"LineEndings": "lf", "TrailingNewline": true}, "NormalizationRules": [{"Pattern": "*.bat", "LineEndings": "crlf"}]}`))
+	if err != nil || configAdapter.Normalization.LineEndings != LINE_ENDINGS_LF || len(configAdapter.NormalizationRules) != 1 {
+		testing.Errorf("Normalization was not parsed: %v", err)
+	}
+	configAdapter = JSONConfigAdapter{}
+	err = configAdapter.ParseConfigurationString([]byte(`{"TemplaterVersion": "1.0", "BasePackage": "com.test", "Language": "java", "Normalization": {"LineEndings": "mac"}}`))
+	if err == nil {
+		testing.Errorf("ParseConfigurationString did not fail when passed invalid line endings")
+	}
 }
 
 func TestAddModelsToContext(testing *testing.T) {
@@ -0,0 +1,123 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+//The exit codes of the levo commandline tool. Every class of failure has its
+//own code so that scripts and CI pipelines can tell them apart.
+const (
+	EXIT_SUCCESS       int = 0
+	EXIT_FAILURE       int = 1
+	EXIT_USAGE         int = 2
+	EXIT_CONFIG        int = 3
+	EXIT_TEMPLATE      int = 4
+	EXIT_TEMPLATE_REPO int = 5
+	EXIT_OUTPUT        int = 6
+	EXIT_USER_DECLINED int = 7
+	EXIT_OUT_OF_DATE   int = 8
+)
+
+//ErrorKind is the class of failure a LevoError belongs to
+type ErrorKind int
+
+const (
+	UnknownError ErrorKind = iota
+	UsageError
+	ConfigError
+	TemplateError
+	TemplateRepoError
+	OutputError
+	UserDeclinedError
+	OutOfDateError
+)
+
+//LevoError is an error that knows which class of failure caused it, and
+//therefore which exit code the tool should return
+type LevoError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+func (self LevoError) Error() string {
+	return self.Message
+}
+
+func (self LevoError) ExitCode() int {
+	switch self.Kind {
+	case UsageError:
+		return EXIT_USAGE
+	case ConfigError:
+		return EXIT_CONFIG
+	case TemplateError:
+		return EXIT_TEMPLATE
+	case TemplateRepoError:
+		return EXIT_TEMPLATE_REPO
+	case OutputError:
+		return EXIT_OUTPUT
+	case UserDeclinedError:
+		return EXIT_USER_DECLINED
+	case OutOfDateError:
+		return EXIT_OUT_OF_DATE
+	}
+	return EXIT_FAILURE
+}
+
+func (self ErrorKind) String() string {
+	switch self {
+	case UsageError:
+		return "usage"
+	case ConfigError:
+		return "config"
+	case TemplateError:
+		return "template"
+	case TemplateRepoError:
+		return "template repo"
+	case OutputError:
+		return "output"
+	case UserDeclinedError:
+		return "user declined"
+	case OutOfDateError:
+		return "out of date"
+	}
+	return "unknown"
+}
+
+func newLevoError(kind ErrorKind, message string) error {
+	return LevoError{Kind: kind, Message: message}
+}
+
+//wrapLevoError prefixes the message of err. An error that already has a kind
+//keeps it, so the original cause decides the exit code; any other error is
+//given the kind passed in.
+func wrapLevoError(kind ErrorKind, prefix string, err error) error {
+	if levoErr, ok := err.(LevoError); ok {
+		return LevoError{Kind: levoErr.Kind, Message: prefix + levoErr.Message}
+	}
+	return LevoError{Kind: kind, Message: prefix + err.Error()}
+}
+
+func isErrorKind(err error, kind ErrorKind) bool {
+	levoErr, ok := err.(LevoError)
+	return ok && levoErr.Kind == kind
+}
+
+func exitCodeForError(err error) int {
+	if err == nil {
+		return EXIT_SUCCESS
+	}
+	if levoErr, ok := err.(LevoError); ok {
+		return levoErr.ExitCode()
+	}
+	return EXIT_FAILURE
+}
@@ -0,0 +1,69 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExitCodeForError(testing *testing.T) {
+	if code := exitCodeForError(nil); code != EXIT_SUCCESS {
+		testing.Errorf("Expected %v for a nil error. Got %v", EXIT_SUCCESS, code)
+	}
+	if code := exitCodeForError(errors.New("plain")); code != EXIT_FAILURE {
+		testing.Errorf("Expected %v for an untyped error. Got %v", EXIT_FAILURE, code)
+	}
+
+	expectedCodes := map[ErrorKind]int{
+		UsageError:        EXIT_USAGE,
+		ConfigError:       EXIT_CONFIG,
+		TemplateError:     EXIT_TEMPLATE,
+		TemplateRepoError: EXIT_TEMPLATE_REPO,
+		OutputError:       EXIT_OUTPUT,
+		UserDeclinedError: EXIT_USER_DECLINED,
+		OutOfDateError:    EXIT_OUT_OF_DATE,
+		UnknownError:      EXIT_FAILURE,
+	}
+	for kind, expected := range expectedCodes {
+		if code := exitCodeForError(newLevoError(kind, "message")); code != expected {
+			testing.Errorf("Expected %v for kind %v. Got %v", expected, kind, code)
+		}
+	}
+}
+
+func TestWrapLevoError(testing *testing.T) {
+	//untyped errors take the kind they are wrapped with
+	err := wrapLevoError(ConfigError, "Error reading config: ", errors.New("bad json"))
+	if err.Error() != "Error reading config: bad json" {
+		testing.Errorf("Unexpected message: %v", err.Error())
+	}
+	if !isErrorKind(err, ConfigError) {
+		testing.Errorf("Wrapped error did not take the given kind")
+	}
+
+	//typed errors keep their own kind
+	err = wrapLevoError(ConfigError, "Error reading config: ", newLevoError(TemplateRepoError, "Template Repo: offline"))
+	if err.Error() != "Error reading config: Template Repo: offline" {
+		testing.Errorf("Unexpected message: %v", err.Error())
+	}
+	if !isErrorKind(err, TemplateRepoError) {
+		testing.Errorf("Wrapped error lost its original kind")
+	}
+
+	if isErrorKind(errors.New("plain"), UsageError) || isErrorKind(nil, UsageError) {
+		testing.Errorf("Untyped errors should not match any kind")
+	}
+}
@@ -0,0 +1,233 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//Levo remembers what it generated in a .levo directory in the output root, so
+//that later runs can tell generated code from the user's changes.
+const LEVO_STATE_DIRECTORY string = ".levo"
+
+//outputRoot is the directory generated files are written under, set with -out
+//or the OutputDirectory config key. It defaults to the working directory.
+func outputRoot() string {
+	if outputDirectory == "" {
+		return "."
+	}
+	return outputDirectory
+}
+
+func stateDirectory() string {
+	return filepath.Join(outputRoot(), LEVO_STATE_DIRECTORY)
+}
+
+//rootPath resolves a path recorded relative to the output root
+func rootPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(outputRoot(), path)
+}
+
+//relativeToRoot is the inverse of rootPath. Levo records paths relative to the
+//output root, so that its state stays valid wherever it is run from.
+func relativeToRoot(path string) string {
+	relative, err := filepath.Rel(outputRoot(), path)
+	if err != nil {
+		return path
+	}
+	return relative
+}
+
+//statePath is where levo keeps what it knows about path in directory, under
+//its state directory. Paths outside of the output root, written with
+//-allow-escape, are kept under a name made from their absolute path, so that
+//they cannot lead out of directory.
+func statePath(directory string, path string) string {
+	relative := relativeToRoot(path)
+	if filepath.IsAbs(relative) || isOutsidePath(relative) {
+		absolutePath, err := filepath.Abs(path)
+		if err != nil {
+			absolutePath = path
+		}
+		relative = filepath.Join("escaped", shortChecksum([]byte(absolutePath))+"-"+filepath.Base(path))
+	}
+	return filepath.Join(directory, relative)
+}
+
+func generationBasePath(path string) string {
+	return statePath(filepath.Join(stateDirectory(), "generated"), path)
+}
+
+//readGenerationBase returns the contents levo generated for path on its last
+//run, and false if it has no record of generating that path
+func readGenerationBase(path string) ([]byte, bool, error) {
+	contents, err := ioutil.ReadFile(generationBasePath(path))
+	if os.IsNotExist(err) {
+		return []byte{}, false, nil
+	} else if err != nil {
+		return []byte{}, false, err
+	}
+	return contents, true, nil
+}
+
+func saveGenerationBase(path string, contents []byte, undo *undoLog) error {
+	undo.lock.Lock()
+	defer undo.lock.Unlock()
+	basePath := generationBasePath(path)
+	if err := undo.record(basePath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(basePath), 0755); err != nil {
+		return err
+	}
+	return writeBytesAtomically(basePath, contents, 0644)
+}
+
+func backupDirectory() string {
+	return filepath.Join(stateDirectory(), "backup")
+}
+
+//undoLog remembers the state of every file a run is about to touch, so that
+//the run can be undone. Only the most recent run is kept. Recording a file and
+//changing it happen under lock, so that a rollback never races a write.
+type undoLog struct {
+	Entries  []undoEntry
+	recorded map[string]bool
+	lock     sync.Mutex
+}
+
+//undoEntry is a file touched by the run, relative to the output root. Existed
+//is false if the run created it.
+type undoEntry struct {
+	Path    string
+	Existed bool
+}
+
+func undoLogPath() string {
+	return filepath.Join(backupDirectory(), "undo.json")
+}
+
+func backupPath(path string) string {
+	return statePath(filepath.Join(backupDirectory(), "files"), path)
+}
+
+//beginUndoLog starts recording a new run, discarding the backups of the last one
+func beginUndoLog() (*undoLog, error) {
+	if err := os.RemoveAll(backupDirectory()); err != nil {
+		return nil, err
+	}
+	return &undoLog{Entries: make([]undoEntry, 0), recorded: make(map[string]bool)}, nil
+}
+
+//record backs up path before it is written or removed for the first time in this run
+func (self *undoLog) record(path string) error {
+	if self.recorded[path] {
+		return nil
+	}
+	self.recorded[path] = true
+
+	fileInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		self.Entries = append(self.Entries, undoEntry{Path: relativeToRoot(path), Existed: false})
+		return self.save()
+	} else if err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(backupPath(path)), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(backupPath(path), contents, fileInfo.Mode().Perm()); err != nil {
+		return err
+	}
+	self.Entries = append(self.Entries, undoEntry{Path: relativeToRoot(path), Existed: true})
+	return self.save()
+}
+
+//save writes the log after every entry, so that a run that dies halfway can still be undone
+func (self *undoLog) save() error {
+	contents, err := json.MarshalIndent(self, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(backupDirectory(), 0755); err != nil {
+		return err
+	}
+	return writeBytesAtomically(undoLogPath(), contents, 0644)
+}
+
+//restore puts every recorded file back the way it was before the run
+func (self *undoLog) restore() error {
+	for i := len(self.Entries) - 1; i >= 0; i-- {
+		path := rootPath(self.Entries[i].Path)
+		if !self.Entries[i].Existed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			removeEmptyParents(path)
+			continue
+		}
+		fileInfo, err := os.Stat(backupPath(path))
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadFile(backupPath(path))
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeBytesAtomically(path, contents, fileInfo.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//undoLastRun restores the files touched by the last run of levo
+func undoLastRun() error {
+	contents, err := ioutil.ReadFile(undoLogPath())
+	if os.IsNotExist(err) {
+		return errors.New("Nothing to undo: " + undoLogPath() + " does not exist")
+	} else if err != nil {
+		return err
+	}
+	undo := undoLog{}
+	if err := json.Unmarshal(contents, &undo); err != nil {
+		return errors.New("Invalid undo log " + undoLogPath() + ": " + err.Error())
+	}
+	//nothing is restored unless every entry is
+	for _, entry := range undo.Entries {
+		if err := checkRecordedPath(entry.Path); err != nil {
+			return errors.New("Invalid undo log " + undoLogPath() + ": " + err.Error())
+		}
+	}
+	if err := undo.restore(); err != nil {
+		return err
+	}
+	return os.RemoveAll(backupDirectory())
+}
@@ -0,0 +1,149 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputRoot(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-out")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", filepath.Join(outputDir, "app"))
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Directory: "src/models", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "README", Body: []byte("generated\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "app", "src", "models", "Cats.java")); err != nil {
+		testing.Errorf("File was not written under the output root")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "app", "README")); err != nil {
+		testing.Errorf("File without a directory was not written under the output root")
+	}
+	if _, err := os.Stat(filepath.Join("src", "models", "Cats.java")); !os.IsNotExist(err) {
+		os.RemoveAll("src")
+		testing.Errorf("File was written relative to the working directory")
+	}
+
+	//levo keeps its state in the output root, with paths relative to it
+	manifest, found, err := readManifest()
+	if err != nil || !found {
+		testing.Fatalf("No manifest in the output root: %v", err)
+	}
+	if manifest.Files[0].Path != filepath.Join("src", "models", "Cats.java") {
+		testing.Errorf("Expected a path relative to the output root. Got %v", manifest.Files[0].Path)
+	}
+	if _, err := os.Stat(LEVO_STATE_DIRECTORY); !os.IsNotExist(err) {
+		testing.Errorf("State was written to the working directory")
+	}
+
+	if err := cleanGeneratedFiles(); err != nil {
+		testing.Fatalf("Error cleaning the output root: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "app", "src")); !os.IsNotExist(err) {
+		testing.Errorf("Empty directories were left behind in the output root")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "app")); err != nil {
+		testing.Errorf("The output root itself was removed")
+	}
+
+	if err := writeZipFile(generatedFiles); err != nil {
+		testing.Fatalf("Error writing zip: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "app", "levo_gen.zip")); err != nil {
+		testing.Errorf("Zip was not written to the output root")
+	}
+}
+
+func TestEscapedStatePaths(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-escape")
+	defer os.RemoveAll(outputDir)
+	root := filepath.Join(outputDir, "app", "lib")
+	os.MkdirAll(root, 0755)
+	ioutil.WriteFile(filepath.Join(root, "x.txt"), []byte("mine\n"), 0644)
+	flag.Set("out", root)
+	flag.Set("allow-escape", "true")
+	flag.Set("quiet", "true")
+
+	for _, body := range []string{"first\n", "second\n"} {
+		if err := outputFiles([]levo.GeneratedFile{levo.GeneratedFile{Directory: "../..", FileName: "x.txt", Body: []byte(body)}}); err != nil {
+			testing.Fatalf("Error writing files: %v", err.Error())
+		}
+	}
+	readFile := func(path string) string {
+		contents, _ := ioutil.ReadFile(path)
+		return string(contents)
+	}
+	escapedPath := filepath.Join(outputDir, "x.txt")
+	if readFile(escapedPath) != "second\n" {
+		testing.Errorf("The escaping file was not written: %q", readFile(escapedPath))
+	}
+	if readFile(filepath.Join(root, "x.txt")) != "mine\n" {
+		testing.Errorf("The state kept for an escaping file overwrote a file in the output root: %q", readFile(filepath.Join(root, "x.txt")))
+	}
+	for _, path := range []string{generationBasePath(escapedPath), backupPath(escapedPath)} {
+		if relative, err := filepath.Rel(stateDirectory(), path); err != nil || isOutsidePath(relative) {
+			testing.Errorf("%v is outside of the state directory", path)
+		}
+	}
+
+	if err := undoLastRun(); err != nil {
+		testing.Fatalf("Error undoing the last run: %v", err.Error())
+	}
+	if readFile(escapedPath) != "first\n" || readFile(filepath.Join(root, "x.txt")) != "mine\n" {
+		testing.Errorf("Undo did not restore the escaping file from its backup: %q", readFile(escapedPath))
+	}
+}
+
+func TestOutputDirectoryFromConfig(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	generatedFiles, err := generateFromConfiguration("test-resources/code-gen-config-output-directory.json")
+	if err != nil {
+		testing.Fatalf("Error processing config: %v", err.Error())
+	}
+	if outputDirectory != "test-output" {
+		testing.Errorf("OutputDirectory from the config was not used. Got %v", outputDirectory)
+	}
+
+	//-out takes precedence
+	flag.Set("out", "somewhere-else")
+	generateFromConfiguration("test-resources/code-gen-config-output-directory.json")
+	if outputDirectory != "somewhere-else" {
+		testing.Errorf("-out did not take precedence over the config. Got %v", outputDirectory)
+	}
+
+	flag.Set("out", "")
+	generateFromConfiguration("test-resources/code-gen-config-output-directory.json")
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join("test-output", generatedFiles[0].Directory, generatedFiles[0].FileName)); err != nil {
+		testing.Errorf("Files were not written to the OutputDirectory from the config")
+	}
+}
@@ -15,11 +15,8 @@ limitations under the License.*/
 package main
 
 import (
-	"archive/zip"
-	"bufio"
-	"bytes"
-	"encoding/base64"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/cfmobile/levolib"
 	"io/ioutil"
@@ -29,6 +26,8 @@ import (
 )
 
 func init() {
+	//parse errors are reported like any other error, as an event with -format json
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	setupFlags()
 	setupFlagUsage()
 }
@@ -36,50 +35,107 @@ func init() {
 const LEVO_VERSION string = "1.0.0"
 
 func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+//run does everything main does with args, but returns the exit code instead of exiting
+func run(args []string) int {
 	fmt.Printf("")
 
-	parseFlags()
-	if !checkFlags() {
-		return
+	if err := parseFlags(args); err == flag.ErrHelp {
+		return EXIT_SUCCESS
+	} else if err != nil || !checkFlags() {
+		reportError(newLevoError(UsageError, usageMessage()))
+		return EXIT_USAGE
 	}
+	emitEvent(runEvent{Event: EVENT_START, Version: LEVO_VERSION, Arguments: args})
 
 	generatedFiles, err := processArgs()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		return
+		reportError(err)
+		return exitCodeForError(err)
 	}
 
 	if len(generatedFiles) > 0 {
-		if zipOutput {
-			err := writeZipFile(generatedFiles)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error writing zip: ", err.Error())
-				return
-			}
+		if err := writeGeneratedFiles(generatedFiles); err != nil {
+			reportError(err)
+			return exitCodeForError(err)
+		}
+	}
+	return EXIT_SUCCESS
+}
+
+func writeGeneratedFiles(generatedFiles []levo.GeneratedFile) error {
+	if verifyOnly {
+		return verifyGeneratedFiles(generatedFiles, os.Stdout)
+	}
+	if dryRun || showDiff {
+		plan, err := planOutput(generatedFiles)
+		if err != nil {
+			return wrapLevoError(OutputError, "Error planning files: ", err)
+		}
+		if showDiff {
+			writeOutputDiff(plan, os.Stdout)
 		} else {
-			err := outputFiles(generatedFiles)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error writing files: ", err.Error())
-				return
+			printOutputPlan(plan, os.Stdout)
+			if err := printStaleFiles(plan, os.Stdout); err != nil {
+				return wrapLevoError(OutputError, "Error planning files: ", err)
 			}
 		}
+		return nil
+	}
+
+	if writesToStdout() {
+		if err := writeToStdout(generatedFiles, os.Stdout); err != nil {
+			return wrapLevoError(OutputError, "Error writing to stdout: ", err)
+		}
+		return nil
+	}
+	if archivePath != "" {
+		if err := writeArchive(generatedFiles, archivePath); err != nil {
+			return wrapLevoError(OutputError, "Error writing archive: ", err)
+		}
+		return nil
+	}
+	if zipOutput {
+		if err := writeZipFile(generatedFiles); err != nil {
+			return wrapLevoError(OutputError, "Error writing zip: ", err)
+		}
+		return nil
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		return wrapLevoError(OutputError, "Error writing files: ", err)
 	}
+	return nil
 }
 
 func processArgs() ([]levo.GeneratedFile, error) {
 	if example {
 		err := outputExampleWorkspace()
-		if err != nil && err.Error() != "User Input: n" {
-			return []levo.GeneratedFile{}, errors.New("Error creating example files: " + err.Error())
+		if isErrorKind(err, UserDeclinedError) {
+			return []levo.GeneratedFile{}, err
 		}
-		if err != nil && err.Error() == "User Input: n" {
-			return []levo.GeneratedFile{}, errors.New("Example files not created")
+		if err != nil {
+			return []levo.GeneratedFile{}, wrapLevoError(OutputError, "Error creating example files: ", err)
 		}
-		fmt.Println("Successfully created example directory.\nEnter that directory, take a look, and then try 'levo -config config.json'")
+		reportEvent(eventOutput, runEvent{Event: EVENT_MESSAGE, Message: "Created the example directory"}, "Successfully created example directory.\nEnter that directory, take a look, and then try 'levo -config config.json'")
 		return []levo.GeneratedFile{}, nil
 	}
 	if getVersion {
-		fmt.Printf("Levo - version %v\n", LEVO_VERSION)
+		reportEvent(eventOutput, runEvent{Event: EVENT_MESSAGE, Version: LEVO_VERSION}, "Levo - version "+LEVO_VERSION)
+		return []levo.GeneratedFile{}, nil
+	}
+	if cleanOutput {
+		if err := cleanGeneratedFiles(); err != nil {
+			return []levo.GeneratedFile{}, wrapLevoError(OutputError, "Error cleaning generated files: ", err)
+		}
+		return []levo.GeneratedFile{}, nil
+	}
+	if undoRun {
+		if err := undoLastRun(); err != nil {
+			return []levo.GeneratedFile{}, wrapLevoError(OutputError, "Error undoing the last run: ", err)
+		}
+		reportEvent(eventOutput, runEvent{Event: EVENT_MESSAGE, Message: "Restored the files touched by the last run"}, "Restored the files touched by the last run")
 		return []levo.GeneratedFile{}, nil
 	}
 
@@ -92,10 +148,10 @@ func processArgs() ([]levo.GeneratedFile, error) {
 	if getTemplateFeatures && templatePath != "" {
 		possibleFlags, err := getTemplateFeaturesFromReadMe(templatePath)
 		if err != nil {
-			return []levo.GeneratedFile{}, err
+			return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "", err)
 		}
 		for _, flagParts := range possibleFlags {
-			fmt.Printf("%v:\n%v\n", flagParts[0], flagParts[1])
+			reportEvent(eventOutput, runEvent{Event: EVENT_MESSAGE, Message: flagParts[0] + ": " + strings.TrimSpace(flagParts[1])}, fmt.Sprintf("%v:\n%v", flagParts[0], flagParts[1]))
 		}
 		return []levo.GeneratedFile{}, nil
 	}
@@ -104,14 +160,14 @@ func processArgs() ([]levo.GeneratedFile, error) {
 	if configPath != "" {
 		generatedFiles, err = generateFromConfiguration(configPath)
 		if err != nil {
-			return []levo.GeneratedFile{}, errors.New("Error reading config: " + err.Error())
+			return []levo.GeneratedFile{}, wrapLevoError(ConfigError, "Error reading config: ", err)
 		}
 		return generatedFiles, nil
 
 	} else if len(model) != 0 {
 		models, err := processRawModel(model)
 		if err != nil {
-			return []levo.GeneratedFile{}, errors.New("Error parsing model string: " + err.Error())
+			return []levo.GeneratedFile{}, wrapLevoError(UsageError, "Error parsing model string: ", err)
 		}
 		generatedFiles, err = generateModelsAndTemplates(models, templatePath)
 		if err != nil {
@@ -136,7 +192,7 @@ func processArgs() ([]levo.GeneratedFile, error) {
 		}
 		return generatedFiles, nil
 	} else {
-		return []levo.GeneratedFile{}, errors.New("Unhandled request")
+		return []levo.GeneratedFile{}, newLevoError(UsageError, "Unhandled request")
 	}
 }
 
@@ -144,12 +200,30 @@ func generateFromConfiguration(configFile string) ([]levo.GeneratedFile, error)
 	configAdapter := JSONConfigAdapter{}
 	context, err := configAdapter.ProcessConfigurationFile(configFile)
 	if err != nil {
-		return []levo.GeneratedFile{}, errors.New("Error processing config: " + err.Error())
+		return []levo.GeneratedFile{}, wrapLevoError(ConfigError, "Error processing config: ", err)
 	}
+	reportTemplates(context.Templates)
 	generatedFiles, err := levo.ProcessMappings(context)
 	if err != nil {
-		return []levo.GeneratedFile{}, errors.New("Error generating files from config: " + err.Error())
+		return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "Error generating files from config: ", err)
+	}
+	if err := checkPathCollisions(generatedFiles, context, configAdapter.Mappings); err != nil {
+		return []levo.GeneratedFile{}, err
+	}
+	//flags take precedence over the config
+	if outputDirectory == "" {
+		outputDirectory = configAdapter.OutputDirectory
 	}
+	if archivePath == "" {
+		archivePath = configAdapter.Archive
+	}
+	zipOutput = zipOutput || configAdapter.Zip
+	stampOutput = stampOutput || configAdapter.Stamp
+	recordProvenance(configAdapter.TemplatesDirectory, context.Schema.Models)
+	conflictRules = configAdapter.ConflictRules
+	configFormatters = configAdapter.Formatters
+	normalization = configAdapter.Normalization
+	normalizationRules = configAdapter.NormalizationRules
 	return generatedFiles, nil
 }
 
@@ -166,27 +240,28 @@ func generateModelsAndTemplates(models []levo.Model, templatePath string) ([]lev
 	for _, newModel := range models {
 		addedModel, err := context.AddModelWithName(newModel.Name)
 		if err != nil {
-			return []levo.GeneratedFile{}, errors.New("Error adding models: " + err.Error())
+			return []levo.GeneratedFile{}, wrapLevoError(ConfigError, "Error adding models: ", err)
 		}
 		for _, newProperty := range newModel.Properties {
 			_, err := addedModel.AddProperty(newProperty.RemoteIdentifier, newProperty.LocalIdentifier, newProperty.PropertyType)
 			if err != nil {
-				return []levo.GeneratedFile{}, errors.New("Error adding properties: " + err.Error())
+				return []levo.GeneratedFile{}, wrapLevoError(ConfigError, "Error adding properties: ", err)
 			}
 		}
 	}
 
 	templates, err := addTemplatePath(&context, templatePath)
 	if err != nil {
-		return []levo.GeneratedFile{}, errors.New("Error adding template: " + err.Error())
+		return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "Error adding template: ", err)
 	}
+	reportTemplates(templates)
 
 	for _, templateFeature := range templateFeatures {
 		if templateFeature == "all" {
 			//set all possible features
 			possibleFlags, err := getTemplateFeaturesFromReadMe(templatePath)
 			if err != nil {
-				return []levo.GeneratedFile{}, err
+				return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "", err)
 			}
 			for _, possibleFlag := range possibleFlags {
 				context.AddTemplateFeature(possibleFlag[0])
@@ -195,7 +270,7 @@ func generateModelsAndTemplates(models []levo.Model, templatePath string) ([]lev
 			//unset all possible features
 			possibleFlags, err := getTemplateFeaturesFromReadMe(templatePath)
 			if err != nil {
-				return []levo.GeneratedFile{}, err
+				return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "", err)
 			}
 			for _, possibleFlag := range possibleFlags {
 				context.RemoveTemplateFeature(possibleFlag[0])
@@ -213,15 +288,20 @@ func generateModelsAndTemplates(models []levo.Model, templatePath string) ([]lev
 		}
 	}
 
-	err = addMappings(&context, templates, models)
+	mapping := mappingForTemplates(templates, models)
+	err = context.AddTemplatesForModelsMapping(mapping.TemplateNames, mapping.ModelNames)
 	if err != nil {
-		return []levo.GeneratedFile{}, errors.New("Error adding mapping: " + err.Error())
+		return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "Error adding mapping: ", err)
 	}
 
 	generatedFiles, err := levo.ProcessMappings(context)
 	if err != nil {
-		return []levo.GeneratedFile{}, errors.New("Error generating files: " + err.Error())
+		return []levo.GeneratedFile{}, wrapLevoError(TemplateError, "Error generating files: ", err)
+	}
+	if err := checkPathCollisions(generatedFiles, context, []modelToTemplateMapping{mapping}); err != nil {
+		return []levo.GeneratedFile{}, err
 	}
+	recordProvenance(templatePath, models)
 	return generatedFiles, nil
 }
 
@@ -241,7 +321,7 @@ func processModelsFromSchema(modelName string, modelNames []string, schemaPath s
 	schemaAdapter := levo.GetJSONSchemaAdapter()
 	schemaObject, err := schemaAdapter.ProcessSchemaFile(schemaPath)
 	if err != nil {
-		return []levo.Model{}, errors.New("Error while reading schema file: " + err.Error())
+		return []levo.Model{}, wrapLevoError(ConfigError, "Error while reading schema file: ", err)
 	}
 
 	if len(modelNames) <= 0 {
@@ -258,7 +338,7 @@ func processModelsFromSchema(modelName string, modelNames []string, schemaPath s
 			}
 		}
 		if !foundInSchema {
-			return []levo.Model{}, errors.New("Schema " + schemaPath + " does not contain " + requestedName)
+			return []levo.Model{}, newLevoError(ConfigError, "Schema "+schemaPath+" does not contain "+requestedName)
 		}
 	}
 	return models, nil
@@ -287,7 +367,9 @@ func getModel(modelString string) (levo.Model, error) {
 	return levo.Model{Name: modelName, Properties: properties}, nil
 }
 
-func addMappings(context *levo.Context, templates []levo.TemplateInfo, models []levo.Model) error {
+//mappingForTemplates maps every levo template given on the command line to
+//every model
+func mappingForTemplates(templates []levo.TemplateInfo, models []levo.Model) modelToTemplateMapping {
 	templateNames := make([]string, 0)
 	modelNames := make([]string, 0)
 	for _, template := range templates {
@@ -299,11 +381,7 @@ func addMappings(context *levo.Context, templates []levo.TemplateInfo, models []
 	for _, model := range models {
 		modelNames = append(modelNames, model.Name)
 	}
-	err := context.AddTemplatesForModelsMapping(templateNames, modelNames)
-	if err != nil {
-		return err
-	}
-	return nil
+	return modelToTemplateMapping{ModelNames: modelNames, TemplateNames: templateNames}
 }
 
 func getTemplateFeaturesFromReadMe(templatePath string) ([][]string, error) {
@@ -325,108 +403,111 @@ func getTemplateFeaturesFromReadMe(templatePath string) ([][]string, error) {
 }
 
 func outputFiles(generatedFiles []levo.GeneratedFile) error {
-	//come back to here when we're done
-	originalDir, err := os.Getwd()
+	plan, err := planOutput(generatedFiles)
+	if err != nil {
+		return err
+	}
+	//a refused run must not discard the backups of the last one
+	if err := checkConflictFailures(plan); err != nil {
+		return err
+	}
+	undo, err := beginUndoLog()
 	if err != nil {
 		return err
 	}
-	defer os.Chdir(originalDir)
+	if !transactional {
+		return applyOutputPlan(plan, undo)
+	}
 
-	overWrite := forceOverwrite
-	input := bufio.NewReader(os.Stdin)
-	for _, generatedFile := range generatedFiles {
-		if generatedFile.Directory != "" {
-			if err := os.MkdirAll(generatedFile.Directory, 0755); err != nil && !os.IsExist(err) {
-				return err
-			}
-			if err := os.Chdir(generatedFile.Directory); err != nil {
-				return err
-			}
+	atomicRun := beginTransaction(undo)
+	defer atomicRun.end()
+	if err := applyOutputPlan(plan, undo); err != nil {
+		return atomicRun.abort(err)
+	}
+	return nil
+}
+
+//applyOutputPlan writes the planned files, recording everything it touches in undo
+func applyOutputPlan(plan []plannedFile, undo *undoLog) error {
+	manifest := newGenerationManifest()
+	conflicts := newConflictResolver()
+	written := make(map[fileStatus]int)
+	skipped := 0
+	for _, planned := range plan {
+		for _, warning := range planned.Warnings {
+			reportWarning(warning)
 		}
-		_, err := os.Open(generatedFile.FileName)
-		//file, err := os.Stat(generatedFile.FileName)
-		if err == nil {
+		if isConflict(planned) {
 			//File already exists
-			if overWrite {
-				err := writeFile(generatedFile.FileName, []byte(generatedFile.Body))
-				if err != nil {
-					return err
+			write, err := conflicts.resolve(planned, undo)
+			if isErrorKind(err, UserDeclinedError) {
+				//quitting keeps what was written so far, and what levo generated before
+				if keepErr := keepPreviousFiles(&manifest); keepErr != nil {
+					return keepErr
 				}
-			} else {
-				//Ask the user if they want to overwrite this file
-				fmt.Printf("The file %s/%s already exists. Overwrite? (y/n) : ", generatedFile.Directory, generatedFile.FileName)
-				answer, err := input.ReadString('\n')
-				if err != nil {
-					return err
-				}
-				if answer == "y\n" {
-					err := writeFile(generatedFile.FileName, []byte(generatedFile.Body))
-					if err != nil {
-						return err
-					}
-					if !alwaysAsk {
-						overWrite = true
-					}
+				if saveErr := saveManifest(manifest, undo); saveErr != nil {
+					return saveErr
 				}
+				return err
+			} else if err != nil {
+				return err
 			}
-		} else {
-			err := writeFile(generatedFile.FileName, []byte(generatedFile.Body))
-			if err != nil {
+			if !write {
+				emitEvent(runEvent{Event: EVENT_FILE, Path: planned.Path, Status: "skipped"})
+				skipped++
+				continue
+			}
+		}
+		//rewriting an unchanged file would only bump its modification time
+		//and trigger needless rebuilds
+		if planned.Status != FileUnchanged {
+			//only once it is certain that the file is written
+			if err := os.MkdirAll(generatedDirectory(planned.Generated), 0755); err != nil {
+				return err
+			}
+			if err := writeFile(planned.Path, planned.Contents, planned.Mode, undo); err != nil {
 				return err
 			}
 		}
-		if err := os.Chdir(originalDir); err != nil {
+		written[planned.Status]++
+		emitEvent(runEvent{Event: EVENT_FILE, Path: planned.Path, Status: planned.Status.outcome(), Size: len(planned.Contents)})
+		if planned.Injected {
+			continue
+		}
+		manifest.addFile(planned.Path, planned.Contents)
+		if err := saveGenerationBase(planned.Path, planned.Pristine, undo); err != nil {
 			return err
 		}
 	}
+	if err := handleStaleFiles(plan, &manifest, undo); err != nil {
+		return err
+	}
+	if err := saveManifest(manifest, undo); err != nil {
+		return err
+	}
+	printOutputSummary(written, skipped, eventOutput)
 	return nil
 }
 
-func writeFile(fileName string, contents []byte) error {
-	if len(contents) >= 14 && string(contents[0:14]) == "<<levobase64>>" {
-		headerlessContents := contents[14:]
-
-		decodedContents := make([]byte, base64.StdEncoding.DecodedLen(len(headerlessContents)))
-		i, err := base64.StdEncoding.Decode(decodedContents, headerlessContents)
-		if err != nil {
-			return err
-		}
-
-		err = ioutil.WriteFile(fileName, decodedContents[:i], 0755)
-		if err != nil {
-			return err
-		}
-	} else {
-		err := ioutil.WriteFile(fileName, contents, 0755)
-		if err != nil {
-			return err
-		}
+//reportTemplates emits an event for every template a run loaded
+func reportTemplates(templates []levo.TemplateInfo) {
+	for _, template := range templates {
+		emitEvent(runEvent{Event: EVENT_TEMPLATE, Template: template.FileName})
 	}
-
-	return nil
 }
 
-func writeZipFile(generatedFiles []levo.GeneratedFile) error {
-	buffer := bytes.NewBuffer(nil)
-	zipWriter := zip.NewWriter(buffer)
-
-	for _, generatedFile := range generatedFiles {
-		file, err := zipWriter.Create(generatedFile.Directory + generatedFile.FileName)
-		if err != nil {
-			return err
-		}
-		_, err = file.Write(generatedFile.Body)
-		if err != nil {
-			return err
-		}
-	}
-	err := zipWriter.Close()
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile("levo_gen.zip", buffer.Bytes(), os.ModePerm)
-	if err != nil {
+//writeFile replaces fileName through a temporary file, so that it never holds
+//half of the new contents
+func writeFile(fileName string, contents []byte, mode os.FileMode, undo *undoLog) error {
+	undo.lock.Lock()
+	defer undo.lock.Unlock()
+	if err := undo.record(fileName); err != nil {
 		return err
 	}
-	return nil
+	return writeBytesAtomically(fileName, contents, mode)
+}
+
+//writeZipFile writes the generated files to levo_gen.zip in the output root
+func writeZipFile(generatedFiles []levo.GeneratedFile) error {
+	return writeArchive(generatedFiles, rootPath(DEFAULT_ARCHIVE_NAME))
 }
@@ -56,9 +56,30 @@ func cleanup() {
 			fmt.Println(err.Error())
 		}
 	}
+	_, err = os.Stat("test-output")
+	if err == nil {
+		if err := os.RemoveAll("test-output"); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+	_, err = os.Stat(LEVO_STATE_DIRECTORY)
+	if err == nil {
+		if err := os.RemoveAll(LEVO_STATE_DIRECTORY); err != nil {
+			fmt.Println(err.Error())
+		}
+	}
 	resetFlags()
 }
 
+//testTempDir creates a directory for a test to write to. Removing it is up to the test.
+func testTempDir(testing *testing.T, prefix string) string {
+	dir, err := ioutil.TempDir("", prefix)
+	if err != nil {
+		testing.Fatalf("Could not create a temporary directory (not a code failure): %v", err.Error())
+	}
+	return dir
+}
+
 func resetFlags() {
 	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	setupFlags()
@@ -76,28 +97,43 @@ func TestMain(testing *testing.T) {
 
 	//test with no filename
 	cleanup()
-	main()
+	if code := run([]string{}); code != EXIT_USAGE {
+		testing.Errorf("Expected exit code %v with no arguments. Got %v", EXIT_USAGE, code)
+	}
 
 	//test with invalid filename
 	cleanup()
 	flag.Set("config", "thisisn'tagoodfilename")
-	main()
+	if code := run([]string{}); code != EXIT_CONFIG {
+		testing.Errorf("Expected exit code %v with an invalid config. Got %v", EXIT_CONFIG, code)
+	}
 
 	//test with valid filename
 	cleanup()
 	flag.Set("config", "test-resources/code-gen-config.json")
-	main()
+	if code := run([]string{}); code != EXIT_SUCCESS {
+		testing.Errorf("Expected exit code %v with a valid config. Got %v", EXIT_SUCCESS, code)
+	}
 
 	//test with example flag
 	cleanup()
 	flag.Set("example", "true")
-	main()
+	run([]string{})
 
 	//test with example flag
 	cleanup()
 	flag.Set("config", "test-resources/code-gen-config.json")
 	flag.Set("z", "true")
-	main()
+	if code := run([]string{}); code != EXIT_SUCCESS {
+		testing.Errorf("Expected exit code %v when writing a zip. Got %v", EXIT_SUCCESS, code)
+	}
+
+	//test with a config that maps broken templates
+	cleanup()
+	flag.Set("config", "test-resources/code-gen-config-bad-data.json")
+	if code := run([]string{}); code == EXIT_SUCCESS {
+		testing.Errorf("Expected a failing exit code with a broken config")
+	}
 }
 
 func TestProcessArgs(testing *testing.T) {
@@ -0,0 +1,312 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//The manifest lists every file the last run of levo generated, so that levo
+//can later remove them again or notice that they are no longer generated.
+//Projects that generate with more than one invocation keep the files of the
+//runs with other inputs in OtherRuns, where they are never seen as stale.
+type generationManifest struct {
+	LevoVersion string
+	Inputs      generationInputs
+	Files       []manifestEntry
+	OtherRuns   []generationRun `json:",omitempty"`
+}
+
+//generationRun is what a run with other inputs than the last one generated
+type generationRun struct {
+	Inputs generationInputs
+	Files  []manifestEntry
+}
+
+//manifestEntry paths are relative to the output root
+type manifestEntry struct {
+	Path string
+	Hash string
+}
+
+//generationInputs are the arguments the generated files were produced from
+type generationInputs struct {
+	Arguments  []string
+	ConfigPath string   `json:",omitempty"`
+	SchemaPath string   `json:",omitempty"`
+	Template   string   `json:",omitempty"`
+	Models     []string `json:",omitempty"`
+	//OutputDirectory is empty when generating into the working directory
+	OutputDirectory string `json:",omitempty"`
+}
+
+func manifestPath() string {
+	return filepath.Join(stateDirectory(), "manifest.json")
+}
+
+func contentHash(contents []byte) string {
+	hash := sha256.Sum256(contents)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+func currentGenerationInputs() generationInputs {
+	inputs := generationInputs{Arguments: os.Args[1:], ConfigPath: configPath, SchemaPath: schemaPath, Template: templatePath, OutputDirectory: outputDirectory}
+	inputs.Models = append(inputs.Models, model...)
+	if modelName != "" {
+		inputs.Models = append(inputs.Models, modelName)
+	}
+	inputs.Models = append(inputs.Models, modelNames...)
+	return inputs
+}
+
+//sameInputs is true if two runs generate the same set of files. A config
+//describes the whole run; otherwise it is the template, schema and models. The
+//arguments that only change how files are written do not count.
+func sameInputs(inputs generationInputs, other generationInputs) bool {
+	if inputs.ConfigPath != "" || other.ConfigPath != "" {
+		return filepath.Clean(inputs.ConfigPath) == filepath.Clean(other.ConfigPath)
+	}
+	if inputs.Template != other.Template || inputs.SchemaPath != other.SchemaPath || len(inputs.Models) != len(other.Models) {
+		return false
+	}
+	for i := range inputs.Models {
+		if inputs.Models[i] != other.Models[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newGenerationManifest() generationManifest {
+	return generationManifest{LevoVersion: LEVO_VERSION, Inputs: currentGenerationInputs(), Files: make([]manifestEntry, 0)}
+}
+
+//addFile records a generated file. Files written outside of the output root
+//with -allow-escape are not recorded, since clean and stale files only ever
+//touch files inside it.
+func (self *generationManifest) addFile(path string, contents []byte) {
+	relative := relativeToRoot(path)
+	if filepath.IsAbs(relative) || isOutsidePath(relative) {
+		return
+	}
+	self.Files = append(self.Files, manifestEntry{Path: relative, Hash: contentHash(contents)})
+}
+
+//confinedFiles leaves out the entries that lead outside of the output root,
+//which a manifest only holds if it was edited by hand
+func confinedFiles(files []manifestEntry) []manifestEntry {
+	confined := make([]manifestEntry, 0, len(files))
+	for _, entry := range files {
+		if err := checkRecordedPath(entry.Path); err != nil {
+			reportWarning("Ignoring an entry of " + manifestPath() + ": " + err.Error())
+			continue
+		}
+		confined = append(confined, entry)
+	}
+	return confined
+}
+
+//readManifest returns false if levo has not generated anything here before
+func readManifest() (generationManifest, bool, error) {
+	contents, err := ioutil.ReadFile(manifestPath())
+	if os.IsNotExist(err) {
+		return generationManifest{}, false, nil
+	} else if err != nil {
+		return generationManifest{}, false, err
+	}
+	manifest := generationManifest{}
+	if err := json.Unmarshal(contents, &manifest); err != nil {
+		return generationManifest{}, false, errors.New("Invalid manifest " + manifestPath() + ": " + err.Error())
+	}
+	manifest.Files = confinedFiles(manifest.Files)
+	for i := range manifest.OtherRuns {
+		manifest.OtherRuns[i].Files = confinedFiles(manifest.OtherRuns[i].Files)
+	}
+	return manifest, true, nil
+}
+
+//readPreviousRun returns what the last run with the same inputs as this one
+//generated, and false if there was none
+func readPreviousRun() (generationManifest, bool, error) {
+	manifest, found, err := readManifest()
+	if err != nil || !found {
+		return generationManifest{}, false, err
+	}
+	inputs := currentGenerationInputs()
+	if sameInputs(manifest.Inputs, inputs) {
+		return generationManifest{LevoVersion: manifest.LevoVersion, Inputs: manifest.Inputs, Files: manifest.Files}, true, nil
+	}
+	for _, run := range manifest.OtherRuns {
+		if sameInputs(run.Inputs, inputs) {
+			return generationManifest{LevoVersion: manifest.LevoVersion, Inputs: run.Inputs, Files: run.Files}, true, nil
+		}
+	}
+	return generationManifest{}, false, nil
+}
+
+//allFiles lists the files of every run in the manifest
+func (self generationManifest) allFiles() []manifestEntry {
+	files := append([]manifestEntry{}, self.Files...)
+	for _, run := range self.OtherRuns {
+		files = append(files, run.Files...)
+	}
+	return files
+}
+
+//keepOtherRuns carries over the runs of previous with other inputs than
+//manifest. Files that manifest lists now belong to its run.
+func (self *generationManifest) keepOtherRuns(previous generationManifest) {
+	recorded := make(map[string]bool)
+	for _, entry := range self.Files {
+		recorded[entry.Path] = true
+	}
+	runs := append([]generationRun{generationRun{Inputs: previous.Inputs, Files: previous.Files}}, previous.OtherRuns...)
+	for _, run := range runs {
+		if sameInputs(run.Inputs, self.Inputs) {
+			continue
+		}
+		files := make([]manifestEntry, 0)
+		for _, entry := range run.Files {
+			if !recorded[entry.Path] {
+				files = append(files, entry)
+			}
+		}
+		if len(files) > 0 {
+			self.OtherRuns = append(self.OtherRuns, generationRun{Inputs: run.Inputs, Files: files})
+		}
+	}
+}
+
+func saveManifest(manifest generationManifest, undo *undoLog) error {
+	previous, found, err := readManifest()
+	if err != nil {
+		return err
+	}
+	if found {
+		manifest.keepOtherRuns(previous)
+	}
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	undo.lock.Lock()
+	defer undo.lock.Unlock()
+	if err := undo.record(manifestPath()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(stateDirectory(), 0755); err != nil {
+		return err
+	}
+	return writeBytesAtomically(manifestPath(), contents, 0644)
+}
+
+//keepPreviousFiles carries over every entry of the previous run with the same
+//inputs that manifest does not list yet
+func keepPreviousFiles(manifest *generationManifest) error {
+	previous, _, err := readPreviousRun()
+	if err != nil {
+		return err
+	}
+	recorded := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		recorded[entry.Path] = true
+	}
+	for _, entry := range previous.Files {
+		if !recorded[entry.Path] {
+			manifest.Files = append(manifest.Files, entry)
+		}
+	}
+	return nil
+}
+
+//cleanGeneratedFiles removes every file listed in the manifest, whichever run
+//generated it, along with levo's record of generating it. Files that were
+//changed since they were generated are kept unless -quiet is set. The clean
+//itself can be undone.
+func cleanGeneratedFiles() error {
+	manifest, found, err := readManifest()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("Nothing to clean: " + manifestPath() + " does not exist")
+	}
+
+	undo, err := beginUndoLog()
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, entry := range manifest.allFiles() {
+		path := rootPath(entry.Path)
+		contents, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if contentHash(contents) != entry.Hash && !forceOverwrite {
+			reportWarning(path + " was changed since it was generated and was not removed")
+			continue
+		}
+		if err := removeRecordedFile(path, undo); err != nil {
+			return err
+		}
+		if err := removeRecordedFile(generationBasePath(path), undo); err != nil {
+			return err
+		}
+		emitEvent(runEvent{Event: EVENT_FILE, Path: path, Status: "deleted"})
+		removed++
+	}
+	if err := removeRecordedFile(manifestPath(), undo); err != nil {
+		return err
+	}
+	reportEvent(eventOutput, runEvent{Event: EVENT_SUMMARY, Counts: map[string]int{"deleted": removed}}, fmt.Sprintf("Removed %d generated files", removed))
+	return nil
+}
+
+func removeRecordedFile(path string, undo *undoLog) error {
+	undo.lock.Lock()
+	defer undo.lock.Unlock()
+	if err := undo.record(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeEmptyParents(path)
+	return nil
+}
+
+//removeEmptyParents removes the directories between path and the output root
+//that are now empty. Directories that still hold anything are left alone.
+func removeEmptyParents(path string) {
+	relative := relativeToRoot(path)
+	if filepath.IsAbs(relative) {
+		return
+	}
+	for dir := filepath.Dir(relative); dir != "." && dir != ".." && dir != string(filepath.Separator); dir = filepath.Dir(dir) {
+		if os.Remove(rootPath(dir)) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFilesWritesManifest(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-manifest")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	manifest, found, err := readManifest()
+	if err != nil || !found {
+		testing.Fatalf("No manifest after writing files: %v", err)
+	}
+	if manifest.LevoVersion != LEVO_VERSION || len(manifest.Files) != 2 {
+		testing.Fatalf("Unexpected manifest: %v", manifest)
+	}
+	if manifest.Files[0].Path != "Cats.java" || manifest.Files[0].Hash != contentHash([]byte("class Cats {}\n")) {
+		testing.Errorf("Unexpected manifest entry: %v", manifest.Files[0])
+	}
+}
+
+func TestCleanGeneratedFiles(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-clean")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	if err := cleanGeneratedFiles(); err == nil {
+		testing.Errorf("No error when cleaning without a manifest")
+	}
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	ioutil.WriteFile(filepath.Join(outputDir, "Dogs.java"), []byte("class Dogs { int legs; }\n"), 0644)
+
+	if err := cleanGeneratedFiles(); err != nil {
+		testing.Fatalf("Error cleaning generated files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Cats.java")); !os.IsNotExist(err) {
+		testing.Errorf("A generated file was not removed")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dogs.java")); err != nil {
+		testing.Errorf("A file changed by the user was removed")
+	}
+	if _, found, _ := readManifest(); found {
+		testing.Errorf("The manifest was not removed")
+	}
+
+	//the clean itself can be undone
+	if err := undoLastRun(); err != nil {
+		testing.Fatalf("Error undoing a clean: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Cats.java")); err != nil {
+		testing.Errorf("A cleaned file was not restored")
+	}
+}
+
+func TestRecordedPathsAreConfined(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	parentDir := testTempDir(testing, "levo-confined")
+	defer os.RemoveAll(parentDir)
+	outputDir := filepath.Join(parentDir, "app")
+	flag.Set("out", outputDir)
+	outsidePath := filepath.Join(parentDir, "Cats.java")
+	ioutil.WriteFile(outsidePath, []byte("class Cats {}\n"), 0644)
+
+	os.MkdirAll(stateDirectory(), 0755)
+	manifest := `{"Files": [{"Path": "../Cats.java", "Hash": "` + contentHash([]byte("class Cats {}\n")) + `"}]}`
+	ioutil.WriteFile(manifestPath(), []byte(manifest), 0644)
+	if err := cleanGeneratedFiles(); err != nil {
+		testing.Errorf("Error cleaning with an entry outside of the output directory: %v", err.Error())
+	}
+	if _, err := os.Stat(outsidePath); err != nil {
+		testing.Errorf("Clean removed a file outside of the output directory")
+	}
+
+	//a run after one with -allow-escape neither fails nor touches what it wrote
+	ioutil.WriteFile(manifestPath(), []byte(manifest), 0644)
+	flag.Set("allow-escape", "true")
+	if err := outputFiles([]levo.GeneratedFile{levo.GeneratedFile{Directory: "..", FileName: "Dogs.java", Body: []byte("class Dogs {}\n")}}); err != nil {
+		testing.Fatalf("Error writing a file with -allow-escape: %v", err.Error())
+	}
+	if recorded, _, _ := readManifest(); len(recorded.allFiles()) != 1 || recorded.allFiles()[0].Path != filepath.Join("..", "Cats.java") {
+		testing.Errorf("A file outside of the output directory was recorded: %v", recorded.allFiles())
+	}
+	flag.Set("allow-escape", "false")
+	flag.Set("stale", STALE_DELETE)
+	if err := outputFiles([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")}}); err != nil {
+		testing.Errorf("Error writing files after a run with -allow-escape: %v", err.Error())
+	}
+	if _, err := os.Stat(outsidePath); err != nil {
+		testing.Errorf("A file outside of the output directory was deleted as stale")
+	}
+	os.Remove(manifestPath())
+
+	os.MkdirAll(backupDirectory(), 0755)
+	ioutil.WriteFile(undoLogPath(), []byte(`{"Entries": [{"Path": "../Cats.java", "Existed": false}]}`), 0644)
+	if err := undoLastRun(); err == nil {
+		testing.Errorf("No error when undoing a file outside of the output directory")
+	}
+	if _, err := os.Stat(outsidePath); err != nil {
+		testing.Errorf("A file outside of the output directory was removed")
+	}
+}
+
+func TestUndoLastRun(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-undo")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	if err := undoLastRun(); err == nil {
+		testing.Errorf("No error when there is nothing to undo")
+	}
+
+	existingPath := filepath.Join(outputDir, "Cats.java")
+	ioutil.WriteFile(existingPath, []byte("class Cats { int lives; }\n"), 0600)
+	flag.Set("quiet", "true")
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	if err := undoLastRun(); err != nil {
+		testing.Fatalf("Error undoing the last run: %v", err.Error())
+	}
+	contents, err := ioutil.ReadFile(existingPath)
+	if err != nil || string(contents) != "class Cats { int lives; }\n" {
+		testing.Errorf("An overwritten file was not restored: %q", string(contents))
+	}
+	if fileInfo, err := os.Stat(existingPath); err != nil || fileInfo.Mode().Perm() != 0600 {
+		testing.Errorf("The mode of an overwritten file was not restored")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dogs.java")); !os.IsNotExist(err) {
+		testing.Errorf("A created file was not removed")
+	}
+	if _, found, _ := readManifest(); found {
+		testing.Errorf("The manifest written by the undone run was not removed")
+	}
+}
@@ -0,0 +1,138 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const CONFLICT_START string = "<<<<<<< current\n"
+const CONFLICT_SEPARATOR string = "=======\n"
+const CONFLICT_END string = ">>>>>>> generated\n"
+
+//mergeHunk replaces the base lines [Start, End) with Lines. Side is 0 for the
+//file on disk and 1 for the newly generated file.
+type mergeHunk struct {
+	Side  int
+	Start int
+	End   int
+	Lines []string
+}
+
+//hunksFromDiff groups an edit script into the ranges of the base it replaces
+func hunksFromDiff(ops []diffOp, side int) []mergeHunk {
+	hunks := make([]mergeHunk, 0)
+	basePosition := 0
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			basePosition++
+			i++
+			continue
+		}
+		hunk := mergeHunk{Side: side, Start: basePosition, End: basePosition, Lines: []string{}}
+		for ; i < len(ops) && ops[i].Kind != ' '; i++ {
+			if ops[i].Kind == '-' {
+				hunk.End++
+			} else {
+				hunk.Lines = append(hunk.Lines, ops[i].Line)
+			}
+		}
+		basePosition = hunk.End
+		hunks = append(hunks, hunk)
+	}
+	return hunks
+}
+
+//applyHunks returns the base lines [start, end) with the given hunks applied
+func applyHunks(base []string, start int, end int, hunks []mergeHunk) []string {
+	lines := make([]string, 0)
+	position := start
+	for _, hunk := range hunks {
+		lines = append(lines, base[position:hunk.Start]...)
+		lines = append(lines, hunk.Lines...)
+		position = hunk.End
+	}
+	return append(lines, base[position:end]...)
+}
+
+func sameLines(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//mergeLines performs a three way merge of the changes from base to current and
+//from base to generated. Where both changed the same lines differently, both
+//versions are written between conflict markers. It returns the merged contents
+//and the number of conflicts.
+func mergeLines(base []byte, current []byte, generated []byte) ([]byte, int) {
+	baseLines := splitLines(base)
+	hunks := append(hunksFromDiff(diffLines(baseLines, splitLines(current)), 0), hunksFromDiff(diffLines(baseLines, splitLines(generated)), 1)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].Start < hunks[j].Start
+	})
+
+	merged := make([]string, 0)
+	conflicts := 0
+	position := 0
+	for i := 0; i < len(hunks); {
+		//changes that overlap or touch are resolved together
+		start, end := hunks[i].Start, hunks[i].End
+		group := [2][]mergeHunk{}
+		for ; i < len(hunks) && hunks[i].Start <= end; i++ {
+			group[hunks[i].Side] = append(group[hunks[i].Side], hunks[i])
+			if hunks[i].End > end {
+				end = hunks[i].End
+			}
+		}
+
+		merged = append(merged, baseLines[position:start]...)
+		currentLines := applyHunks(baseLines, start, end, group[0])
+		generatedLines := applyHunks(baseLines, start, end, group[1])
+		if len(group[0]) == 0 || sameLines(currentLines, generatedLines) {
+			merged = append(merged, generatedLines...)
+		} else if len(group[1]) == 0 {
+			merged = append(merged, currentLines...)
+		} else {
+			conflicts++
+			merged = append(merged, CONFLICT_START)
+			merged = append(merged, terminateLines(currentLines)...)
+			merged = append(merged, CONFLICT_SEPARATOR)
+			merged = append(merged, terminateLines(generatedLines)...)
+			merged = append(merged, CONFLICT_END)
+		}
+		position = end
+	}
+	merged = append(merged, baseLines[position:]...)
+	return []byte(strings.Join(merged, "")), conflicts
+}
+
+//terminateLines makes sure the last line ends in a newline, so that conflict
+//markers always start on a line of their own
+func terminateLines(lines []string) []string {
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		terminated := append([]string{}, lines...)
+		terminated[len(terminated)-1] += "\n"
+		return terminated
+	}
+	return lines
+}
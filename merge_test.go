@@ -0,0 +1,94 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeLines(testing *testing.T) {
+	base := "package a;\nclass Cats {\n  int legs;\n}\n"
+
+	//changes to different lines are combined
+	current := "package a;\nimport b;\nclass Cats {\n  int legs;\n}\n"
+	generated := "package a;\nclass Cats {\n  int legs;\n  int tails;\n}\n"
+	merged, conflicts := mergeLines([]byte(base), []byte(current), []byte(generated))
+	expecting := "package a;\nimport b;\nclass Cats {\n  int legs;\n  int tails;\n}\n"
+	if conflicts != 0 || string(merged) != expecting {
+		testing.Errorf("Expecting:\n%s\nGot (%d conflicts):\n%s\n", expecting, conflicts, string(merged))
+	}
+
+	//the same change on both sides is not a conflict
+	merged, conflicts = mergeLines([]byte(base), []byte(generated), []byte(generated))
+	if conflicts != 0 || string(merged) != generated {
+		testing.Errorf("Expecting:\n%s\nGot (%d conflicts):\n%s\n", generated, conflicts, string(merged))
+	}
+
+	//untouched files take the generated contents
+	merged, conflicts = mergeLines([]byte(base), []byte(base), []byte(generated))
+	if conflicts != 0 || string(merged) != generated {
+		testing.Errorf("Expecting:\n%s\nGot (%d conflicts):\n%s\n", generated, conflicts, string(merged))
+	}
+
+	//different changes to the same line conflict
+	current = "package a;\nclass Cats {\n  long legs;\n}\n"
+	generated = "package a;\nclass Cats {\n  short legs;\n}\n"
+	merged, conflicts = mergeLines([]byte(base), []byte(current), []byte(generated))
+	expecting = "package a;\nclass Cats {\n" + CONFLICT_START + "  long legs;\n" + CONFLICT_SEPARATOR + "  short legs;\n" + CONFLICT_END + "}\n"
+	if conflicts != 1 || string(merged) != expecting {
+		testing.Errorf("Expecting:\n%s\nGot (%d conflicts):\n%s\n", expecting, conflicts, string(merged))
+	}
+}
+
+func TestMergeRegeneration(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-merge")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	path := filepath.Join(outputDir, "Cats.java")
+
+	//the first run records what was generated
+	flag.Set("merge", "true")
+	first := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {\n  int legs;\n}\n")}}
+	if err := outputFiles(first); err != nil {
+		testing.Fatalf("Error when writing valid files: %v", err.Error())
+	}
+	base, found, err := readGenerationBase(path)
+	if err != nil || !found || string(base) != string(first[0].Body) {
+		testing.Fatalf("The generated contents were not recorded: %q %v %v", string(base), found, err)
+	}
+
+	//the user edits the file and the template changes
+	ioutil.WriteFile(path, []byte("// mine\nclass Cats {\n  int legs;\n}\n"), 0644)
+	second := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {\n  int legs;\n  int tails;\n}\n")}}
+	if err := outputFiles(second); err != nil {
+		testing.Fatalf("Error when writing valid files: %v", err.Error())
+	}
+	contents, _ := ioutil.ReadFile(path)
+	expecting := "// mine\nclass Cats {\n  int legs;\n  int tails;\n}\n"
+	if string(contents) != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, string(contents))
+	}
+	base, _, _ = readGenerationBase(path)
+	if string(base) != string(second[0].Body) {
+		testing.Errorf("The newly generated contents were not recorded: %q", string(base))
+	}
+}
@@ -0,0 +1,108 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path"
+)
+
+const LINE_ENDINGS_LF string = "lf"
+const LINE_ENDINGS_CRLF string = "crlf"
+const BOM_ADD string = "add"
+const BOM_REMOVE string = "remove"
+
+var UTF8_BOM []byte = []byte{0xEF, 0xBB, 0xBF}
+
+//outputNormalization settles the line endings, byte order mark and trailing
+//newline of generated text files, whatever the templates produced. Empty
+//settings leave the file as generated. In NormalizationRules, Pattern picks the
+//files a rule applies to.
+type outputNormalization struct {
+	Pattern         string `json:",omitempty"`
+	LineEndings     string
+	ByteOrderMark   string
+	TrailingNewline bool
+}
+
+var normalization outputNormalization
+var normalizationRules []outputNormalization
+
+func validateNormalization(settings outputNormalization) error {
+	if settings.LineEndings != "" && settings.LineEndings != LINE_ENDINGS_LF && settings.LineEndings != LINE_ENDINGS_CRLF {
+		return errors.New("Invalid line endings '" + settings.LineEndings + "'. Expected lf or crlf")
+	}
+	if settings.ByteOrderMark != "" && settings.ByteOrderMark != BOM_ADD && settings.ByteOrderMark != BOM_REMOVE {
+		return errors.New("Invalid byte order mark '" + settings.ByteOrderMark + "'. Expected add or remove")
+	}
+	return nil
+}
+
+func validateNormalizationRules(rules []outputNormalization) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+			return errors.New("Invalid normalization pattern '" + rule.Pattern + "'")
+		}
+		if err := validateNormalization(rule); err != nil {
+			return errors.New(rule.Pattern + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+//normalizationFor returns the first rule that matches relativePath, or else
+//the settings for every file. A rule replaces those settings as a whole.
+func normalizationFor(relativePath string) outputNormalization {
+	for _, rule := range normalizationRules {
+		if matchesPathPattern(rule.Pattern, relativePath) {
+			return rule
+		}
+	}
+	return normalization
+}
+
+//normalizeFileContents applies the normalization for relativePath to contents.
+//Binary files are left alone.
+func normalizeFileContents(relativePath string, contents []byte) []byte {
+	if isBinaryContents(contents) {
+		return contents
+	}
+	settings := normalizationFor(relativePath)
+	hasBOM := bytes.HasPrefix(contents, UTF8_BOM)
+	contents = bytes.TrimPrefix(contents, UTF8_BOM)
+
+	newline := []byte("\n")
+	if settings.LineEndings != "" {
+		contents = bytes.Replace(contents, []byte("\r\n"), []byte("\n"), -1)
+		if settings.LineEndings == LINE_ENDINGS_CRLF {
+			contents = bytes.Replace(contents, []byte("\n"), []byte("\r\n"), -1)
+			newline = []byte("\r\n")
+		}
+	} else if bytes.Contains(contents, []byte("\r\n")) {
+		newline = []byte("\r\n")
+	}
+	if settings.TrailingNewline {
+		contents = bytes.TrimRight(contents, " \t\r\n")
+		if len(contents) > 0 {
+			contents = append(contents, newline...)
+		}
+	}
+
+	if settings.ByteOrderMark == BOM_ADD || (hasBOM && settings.ByteOrderMark != BOM_REMOVE) {
+		contents = append(append([]byte{}, UTF8_BOM...), contents...)
+	}
+	return contents
+}
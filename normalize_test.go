@@ -0,0 +1,109 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeFileContents(testing *testing.T) {
+	defer func() {
+		normalization = outputNormalization{}
+		normalizationRules = nil
+	}()
+	normalization = outputNormalization{}
+	normalizationRules = nil
+	if contents := normalizeFileContents("Cats.java", []byte("a\r\nb\n\n")); string(contents) != "a\r\nb\n\n" {
+		testing.Errorf("File was changed without any normalization: %q", contents)
+	}
+
+	normalization = outputNormalization{LineEndings: LINE_ENDINGS_LF, TrailingNewline: true}
+	normalizationRules = []outputNormalization{
+		outputNormalization{Pattern: "*.bat", LineEndings: LINE_ENDINGS_CRLF, ByteOrderMark: BOM_ADD, TrailingNewline: true},
+		outputNormalization{Pattern: "*.txt", ByteOrderMark: BOM_REMOVE},
+	}
+	expected := map[string][2]string{
+		"Cats.java":       {"class Cats {\r\n}\r\n\r\n  \n", "class Cats {\n}\n"},
+		"Dogs.java":       {"class Dogs {}", "class Dogs {}\n"},
+		"scripts/run.bat": {"echo cats\necho dogs", "\xEF\xBB\xBFecho cats\r\necho dogs\r\n"},
+		"notes.txt":       {"\xEF\xBB\xBFcats\r\n\n", "cats\r\n\n"},
+		"empty.java":      {"\n\n", ""},
+	}
+	for relativePath, contents := range expected {
+		if normalized := normalizeFileContents(relativePath, []byte(contents[0])); string(normalized) != contents[1] {
+			testing.Errorf("Expected %v to be normalized to %q. Got %q", relativePath, contents[1], normalized)
+		}
+	}
+	if normalized := normalizeFileContents("icon.bat", []byte{'a', 0, '\n'}); len(normalized) != 3 {
+		testing.Errorf("Binary file was normalized: %q", normalized)
+	}
+
+	//a stamped file stays intact after it is normalized
+	defer cleanup()
+	stampOutput = true
+	entries, err := planArchive([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\r\n\r\n")}})
+	if err != nil || string(entries[0].Contents[len(entries[0].Contents)-15:]) != "\nclass Cats {}\n" || isHandEdited(entries[0].Contents) {
+		testing.Errorf("Archived file was not normalized after it was stamped: %q %v", entries[0].Contents, err)
+	}
+}
+
+func TestNormalizeUserCodeAndSnippets(testing *testing.T) {
+	defer cleanup()
+	defer func() { normalization = outputNormalization{} }()
+	outputDir := testTempDir(testing, "levo-normalize")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	normalization = outputNormalization{LineEndings: LINE_ENDINGS_CRLF}
+
+	//the user typed their code with LF line endings
+	ioutil.WriteFile(filepath.Join(outputDir, "Cats.java"), []byte("class Cats {\r\n// levo:begin-user-code fields\r\nint legs;\nint tails;\n// levo:end-user-code\r\n}\r\n"), 0644)
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {\n// levo:begin-user-code fields\n// levo:end-user-code\n// routes\n}\n")},
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("<<levoinject after:// routes>>\nadd(Cats);\n")},
+	}
+	plan, err := planOutput(generatedFiles)
+	if err != nil {
+		testing.Fatalf("Error when planning files: %v", err.Error())
+	}
+	expected := "class Cats {\r\n// levo:begin-user-code fields\r\nint legs;\r\nint tails;\r\n// levo:end-user-code\r\n// routes\r\nadd(Cats);\r\n}\r\n"
+	if string(plan[0].Contents) != expected {
+		testing.Errorf("Expected user code and snippets to be normalized:\n%q\nGot:\n%q", expected, plan[0].Contents)
+	}
+
+	entries, err := planArchive(generatedFiles)
+	if err != nil || string(entries[0].Contents) != "class Cats {\r\n// levo:begin-user-code fields\r\n// levo:end-user-code\r\n// routes\r\nadd(Cats);\r\n}\r\n" {
+		testing.Errorf("Snippet injected into an archived file was not normalized: %q %v", entries[0].Contents, err)
+	}
+}
+
+func TestValidateNormalization(testing *testing.T) {
+	if err := validateNormalization(outputNormalization{LineEndings: "cr"}); err == nil {
+		testing.Errorf("No error for unknown line endings")
+	}
+	if err := validateNormalization(outputNormalization{ByteOrderMark: "yes"}); err == nil {
+		testing.Errorf("No error for an unknown byte order mark setting")
+	}
+	if err := validateNormalizationRules([]outputNormalization{outputNormalization{LineEndings: LINE_ENDINGS_CRLF}}); err == nil {
+		testing.Errorf("No error for a rule without a pattern")
+	}
+	if err := validateNormalizationRules([]outputNormalization{outputNormalization{Pattern: "*.bat", LineEndings: LINE_ENDINGS_CRLF}}); err != nil {
+		testing.Errorf("Valid rule was rejected: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/cfmobile/levolib"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+const BASE64_HEADER string = "<<levobase64>>"
+
+//Generated files are written with DEFAULT_FILE_MODE, unless their body starts
+//with a mode header such as <<levomode:0755>> for scripts like gradlew
+const DEFAULT_FILE_MODE os.FileMode = 0644
+
+var modeHeaderRegex = regexp.MustCompile(`^<<levomode:(.*)>>\r?\n?`)
+
+//fileStatus describes what writing a generated file would do to the disk
+type fileStatus int
+
+const (
+	FileCreated fileStatus = iota
+	FileOverwritten
+	FileUnchanged
+)
+
+func (self fileStatus) String() string {
+	switch self {
+	case FileCreated:
+		return "create"
+	case FileOverwritten:
+		return "overwrite"
+	case FileUnchanged:
+		return "unchanged"
+	}
+	return "unknown"
+}
+
+//outcome is the status once the file was written
+func (self fileStatus) outcome() string {
+	switch self {
+	case FileCreated:
+		return "created"
+	case FileOverwritten:
+		return "updated"
+	case FileUnchanged:
+		return "unchanged"
+	}
+	return "unknown"
+}
+
+//plannedFile is a generated file together with the path it will be written to,
+//the exact bytes that will be written there and how that compares to the disk.
+//Pristine is the output of the templates before anything on disk was merged into it.
+type plannedFile struct {
+	Generated levo.GeneratedFile
+	Path      string
+	Pristine  []byte
+	Contents  []byte
+	Existing  []byte
+	//Mode is the mode the file is written with, ExistingMode that of the file on disk
+	Mode         os.FileMode
+	ExistingMode os.FileMode
+	Status       fileStatus
+	Merged       bool
+	Injected     bool
+	HandEdited   bool
+	//DropsUserCode is set if a user code region of the existing file is no
+	//longer generated, so that writing the file would lose that code
+	DropsUserCode bool
+	Warnings      []string
+}
+
+//renderFileContents turns the body of a generated file into the bytes and the
+//mode it is written with. Files are formatted and stamped here, before they
+//are compared with what is on disk. They are normalized once the code kept
+//from the existing file and the injected snippets are in as well.
+func renderFileContents(relativePath string, body []byte) ([]byte, os.FileMode, error) {
+	mode, body, err := parseFileMode(body)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	contents, err := decodeFileContents(body)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	contents, err = formatFileContents(relativePath, contents)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	if stampOutput {
+		contents = stampFileContents(relativePath, contents)
+	}
+	return contents, mode, nil
+}
+
+//parseFileMode strips the mode header from body. Only permission bits can be
+//set, so a template cannot make a file setuid.
+func parseFileMode(body []byte) (os.FileMode, []byte, error) {
+	match := modeHeaderRegex.FindSubmatch(body)
+	if match == nil {
+		return DEFAULT_FILE_MODE, body, nil
+	}
+	mode, err := strconv.ParseUint(string(match[1]), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, body, errors.New("Invalid file mode " + string(match[1]) + ". Expected an octal mode such as 0755")
+	}
+	return os.FileMode(mode), body[len(match[0]):], nil
+}
+
+//readExistingFile returns the contents and permissions of the file at path
+func readExistingFile(path string) ([]byte, os.FileMode, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return []byte{}, 0, err
+	}
+	return contents, fileInfo.Mode().Perm(), nil
+}
+
+//decodeFileContents turns the body of a generated file into the bytes that
+//belong on disk. Binary assets are base64 encoded behind a <<levobase64>> header.
+func decodeFileContents(body []byte) ([]byte, error) {
+	if !bytes.HasPrefix(body, []byte(BASE64_HEADER)) {
+		return body, nil
+	}
+	headerlessContents := body[len(BASE64_HEADER):]
+
+	decodedContents := make([]byte, base64.StdEncoding.DecodedLen(len(headerlessContents)))
+	i, err := base64.StdEncoding.Decode(decodedContents, headerlessContents)
+	if err != nil {
+		return []byte{}, err
+	}
+	return decodedContents[:i], nil
+}
+
+//outputFile is everything a run generates for one path: the whole file
+//generated there last, if any, and the snippets injected into it. Snippets are
+//only injected once the whole file is rendered, whatever the order of the
+//mappings that generated them.
+type outputFile struct {
+	Path       string
+	Generated  levo.GeneratedFile
+	HasBody    bool
+	Injections []snippetInjection
+}
+
+//collectOutputFiles groups the generated files by the path pathOf gives them,
+//in the order each path first appears. A file generated twice holds what was
+//generated last.
+func collectOutputFiles(generatedFiles []levo.GeneratedFile, pathOf func(levo.GeneratedFile) (string, error)) ([]outputFile, error) {
+	files := make([]outputFile, 0)
+	indexes := make(map[string]int)
+	for _, generatedFile := range generatedFiles {
+		path, err := pathOf(generatedFile)
+		if err != nil {
+			return []outputFile{}, err
+		}
+		injection, isInjection, err := parseSnippetInjection(generatedFile.Body)
+		if err != nil {
+			return []outputFile{}, errors.New(path + ": " + err.Error())
+		}
+		i, found := indexes[path]
+		if !found {
+			i = len(files)
+			indexes[path] = i
+			files = append(files, outputFile{Path: path, Generated: generatedFile})
+		}
+		if isInjection {
+			files[i].Injections = append(files[i].Injections, injection)
+		} else {
+			files[i].Generated = generatedFile
+			files[i].HasBody = true
+		}
+	}
+	return files, nil
+}
+
+//injectSnippets inserts the snippets for this file into contents. A stamp that
+//matched the contents before still matches them afterwards.
+func (self outputFile) injectSnippets(contents []byte) ([]byte, error) {
+	if len(self.Injections) == 0 {
+		return contents, nil
+	}
+	intact := !isHandEdited(contents)
+	for _, injection := range self.Injections {
+		var err error
+		contents, err = injection.apply(contents)
+		if err != nil {
+			return []byte{}, err
+		}
+	}
+	if intact {
+		contents = refreshStamp(contents)
+	}
+	return contents, nil
+}
+
+func planOutput(generatedFiles []levo.GeneratedFile) ([]plannedFile, error) {
+	files, err := collectOutputFiles(generatedFiles, resolveGeneratedPath)
+	if err != nil {
+		return []plannedFile{}, err
+	}
+	plan := make([]plannedFile, 0, len(files))
+	for _, file := range files {
+		planned, err := planFile(file, relativeToRoot(file.Path), readExistingFile)
+		if err != nil {
+			return []plannedFile{}, err
+		}
+		plan = append(plan, planned)
+	}
+	return plan, nil
+}
+
+//planFile renders the file generated for a path, works in the changes made to
+//the existing file, and then injects the snippets for that path. Snippets
+//without a generated file are injected into the existing file. Disk and archive
+//output both go through it: name is the path relative to the output root, and
+//readExisting finds what is already there.
+func planFile(file outputFile, name string, readExisting func(string) ([]byte, os.FileMode, error)) (plannedFile, error) {
+	existingContents, existingMode, err := readExisting(file.Path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return plannedFile{}, err
+	}
+
+	var planned plannedFile
+	if !file.HasBody {
+		if !exists {
+			return plannedFile{}, errors.New(file.Path + ": Cannot inject a snippet into a file that does not exist")
+		}
+		//injecting a snippet leaves the mode of the file alone
+		planned = plannedFile{Generated: file.Generated, Path: file.Path, Existing: existingContents, Contents: existingContents, Mode: existingMode, ExistingMode: existingMode, Injected: true}
+	} else {
+		contents, mode, err := renderFileContents(name, file.Generated.Body)
+		if err != nil {
+			return plannedFile{}, errors.New(file.Path + ": " + err.Error())
+		}
+		//normalized before the merge as well, since the existing file and the
+		//output of the previous run were written normalized
+		contents = normalizeFileContents(name, contents)
+		planned = plannedFile{Generated: file.Generated, Path: file.Path, Pristine: contents, Contents: contents, Mode: mode, Status: FileCreated}
+		if exists {
+			planned.Existing = existingContents
+			planned.ExistingMode = existingMode
+			if !isBinaryContents(contents) {
+				if err := mergeExistingContents(&planned); err != nil {
+					return plannedFile{}, errors.New(file.Path + ": " + err.Error())
+				}
+			}
+		}
+	}
+
+	planned.Contents, err = file.injectSnippets(planned.Contents)
+	if err != nil {
+		return plannedFile{}, errors.New(file.Path + ": " + err.Error())
+	}
+	planned.Contents = normalizeFileContents(name, planned.Contents)
+	if exists {
+		planned.updateStatus()
+		if planned.Status == FileOverwritten && !planned.Injected && isHandEdited(existingContents) {
+			planned.HandEdited = true
+			planned.Warnings = append(planned.Warnings, planned.Path+" was edited by hand since levo generated it")
+		}
+	}
+	return planned, nil
+}
+
+//updateStatus compares the contents to write with the existing file
+func (self *plannedFile) updateStatus() {
+	if self.Status == FileCreated && self.Existing == nil {
+		return
+	}
+	if bytes.Equal(self.Existing, self.Contents) && self.ExistingMode == self.Mode {
+		self.Status = FileUnchanged
+	} else {
+		self.Status = FileOverwritten
+	}
+}
+
+//mergeExistingContents works the changes made to an existing text file into
+//the newly generated contents. With -merge, a file levo generated before is
+//merged three ways against that earlier output; otherwise only the user code
+//regions of the existing file are kept.
+func mergeExistingContents(planned *plannedFile) error {
+	if mergeRegeneration {
+		base, found, err := readGenerationBase(planned.Path)
+		if err != nil {
+			return err
+		}
+		if found {
+			var conflicts int
+			planned.Contents, conflicts = mergeLines(base, planned.Existing, planned.Pristine)
+			planned.Merged = true
+			if conflicts > 0 {
+				planned.Warnings = append(planned.Warnings, fmt.Sprintf("%d merge conflicts in %s", conflicts, planned.Path))
+			}
+			return nil
+		}
+	}
+
+	var droppedIds []string
+	var err error
+	planned.Contents, droppedIds, err = preserveUserCode(planned.Existing, planned.Pristine)
+	if err != nil {
+		return err
+	}
+	planned.DropsUserCode = len(droppedIds) > 0
+	for _, droppedId := range droppedIds {
+		planned.Warnings = append(planned.Warnings, "User code region "+droppedId+" in "+planned.Path+" is no longer generated and will be lost")
+	}
+	return nil
+}
+
+func printOutputPlan(plan []plannedFile, writer io.Writer) {
+	counts := make(map[fileStatus]int)
+	for _, planned := range plan {
+		event := runEvent{Event: EVENT_FILE, Path: planned.Path, Status: planned.Status.String(), Size: len(planned.Contents)}
+		reportEvent(writer, event, fmt.Sprintf("%-9s %8d bytes  %s", planned.Status, len(planned.Contents), planned.Path))
+		counts[planned.Status]++
+	}
+	summary := runEvent{Event: EVENT_SUMMARY, Counts: map[string]int{"create": counts[FileCreated], "overwrite": counts[FileOverwritten], "unchanged": counts[FileUnchanged]}}
+	reportEvent(writer, summary, fmt.Sprintf("%d files: %d to create, %d to overwrite, %d unchanged", len(plan), counts[FileCreated], counts[FileOverwritten], counts[FileUnchanged]))
+}
+
+//printOutputSummary reports what a run did. Files that were not written
+//because of a conflict are counted as skipped rather than by their status.
+func printOutputSummary(written map[fileStatus]int, skipped int, writer io.Writer) {
+	total := written[FileCreated] + written[FileOverwritten] + written[FileUnchanged] + skipped
+	summary := runEvent{Event: EVENT_SUMMARY, Counts: map[string]int{"created": written[FileCreated], "updated": written[FileOverwritten], "unchanged": written[FileUnchanged], "skipped": skipped}}
+	reportEvent(writer, summary, fmt.Sprintf("%d files: %d created, %d updated, %d unchanged, %d skipped", total, written[FileCreated], written[FileOverwritten], written[FileUnchanged], skipped))
+}
@@ -0,0 +1,174 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeFileContents(testing *testing.T) {
+	contents, err := decodeFileContents([]byte("plain text"))
+	if err != nil || string(contents) != "plain text" {
+		testing.Errorf("Plain text was not passed through untouched: %v %v", string(contents), err)
+	}
+
+	contents, err = decodeFileContents([]byte(BASE64_HEADER + "aGVsbG8="))
+	if err != nil || string(contents) != "hello" {
+		testing.Errorf("Base64 body was not decoded: %v %v", string(contents), err)
+	}
+
+	_, err = decodeFileContents([]byte(BASE64_HEADER + "!!!notbase64"))
+	if err == nil {
+		testing.Errorf("No error when decoding a broken base64 body")
+	}
+}
+
+func TestPlanOutput(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-plan")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	ioutil.WriteFile(filepath.Join(outputDir, "Same.java"), []byte("same"), 0644)
+	ioutil.WriteFile(filepath.Join(outputDir, "Changed.java"), []byte("old"), 0644)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "New.java", Body: []byte("new")},
+		levo.GeneratedFile{FileName: "Same.java", Body: []byte("same")},
+		levo.GeneratedFile{FileName: "Changed.java", Body: []byte("changed")},
+	}
+	plan, err := planOutput(generatedFiles)
+	if err != nil {
+		testing.Fatalf("Error when planning valid files: %v", err.Error())
+	}
+
+	expectedStatuses := []fileStatus{FileCreated, FileUnchanged, FileOverwritten}
+	for i, planned := range plan {
+		if planned.Status != expectedStatuses[i] {
+			testing.Errorf("Expected %v to be %v. Got %v", planned.Path, expectedStatuses[i], planned.Status)
+		}
+	}
+
+	output := bytes.NewBuffer(nil)
+	printOutputPlan(plan, output)
+	if !strings.Contains(output.String(), "3 files: 1 to create, 1 to overwrite, 1 unchanged") {
+		testing.Errorf("Unexpected plan summary:\n%v", output.String())
+	}
+	if !strings.Contains(output.String(), filepath.Join(outputDir, "Changed.java")) {
+		testing.Errorf("Plan does not list the final path of each file:\n%v", output.String())
+	}
+
+	//a dry run must not have touched the disk
+	contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "Changed.java"))
+	if string(contents) != "old" {
+		testing.Errorf("Planning output modified an existing file")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "New.java")); err == nil {
+		testing.Errorf("Planning output created a file")
+	}
+}
+
+func TestParseFileMode(testing *testing.T) {
+	mode, body, err := parseFileMode([]byte("class Cats {}\n"))
+	if err != nil || mode != DEFAULT_FILE_MODE || string(body) != "class Cats {}\n" {
+		testing.Errorf("Unexpected mode %o for a body without a mode header", mode)
+	}
+	mode, body, err = parseFileMode([]byte("<<levomode:0755>>\n#!/bin/sh\n"))
+	if err != nil || mode != 0755 || string(body) != "#!/bin/sh\n" {
+		testing.Errorf("Unexpected mode %o and body %q", mode, body)
+	}
+	if _, _, err := parseFileMode([]byte("<<levomode:4755>>\n")); err == nil {
+		testing.Errorf("No error for a setuid mode")
+	}
+	if _, _, err := parseFileMode([]byte("<<levomode:rwx>>\n")); err == nil {
+		testing.Errorf("No error for a mode that is not octal")
+	}
+}
+
+func TestOutputFilesModes(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-modes")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("quiet", "true")
+	ioutil.WriteFile(filepath.Join(outputDir, "Old.java"), []byte("class Old {}\n"), 0755)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "gradlew", Body: []byte("<<levomode:0755>>\n#!/bin/sh\n")},
+		levo.GeneratedFile{FileName: "Old.java", Body: []byte("class Old {}\n")},
+	}
+	plan, _ := planOutput(generatedFiles)
+	if plan[2].Status != FileOverwritten {
+		testing.Errorf("A file whose mode changes was planned as %v", plan[2].Status)
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	expectedModes := map[string]os.FileMode{"Cats.java": 0644, "gradlew": 0755, "Old.java": 0644}
+	for fileName, expected := range expectedModes {
+		fileInfo, err := os.Stat(filepath.Join(outputDir, fileName))
+		if err != nil || fileInfo.Mode().Perm() != expected {
+			testing.Errorf("Expected %v to have mode %o. Got %o", fileName, expected, fileInfo.Mode().Perm())
+		}
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "gradlew"))
+	if string(contents) != "#!/bin/sh\n" {
+		testing.Errorf("Mode header was written to the file: %q", contents)
+	}
+}
+
+func TestOutputFilesSkipsUnchanged(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-unchanged")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	generatedFiles := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")}}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	path := filepath.Join(outputDir, "Cats.java")
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour).Truncate(time.Second)
+	os.Chtimes(path, lastWeek, lastWeek)
+
+	//without -quiet this would prompt, and fail on the empty stdin of the test
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Unchanged file was treated as a conflict: %v", err.Error())
+	}
+	if fileInfo, _ := os.Stat(path); !fileInfo.ModTime().Equal(lastWeek) {
+		testing.Errorf("Unchanged file was rewritten")
+	}
+	if manifest, _, _ := readManifest(); len(manifest.Files) != 1 {
+		testing.Errorf("Unchanged file was dropped from the manifest")
+	}
+}
+
+func TestPrintOutputSummary(testing *testing.T) {
+	output := bytes.Buffer{}
+	printOutputSummary(map[fileStatus]int{FileCreated: 2, FileOverwritten: 1, FileUnchanged: 4}, 3, &output)
+	if output.String() != "10 files: 2 created, 1 updated, 4 unchanged, 3 skipped\n" {
+		testing.Errorf("Unexpected summary %q", output.String())
+	}
+}
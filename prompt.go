@@ -0,0 +1,83 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const PROMPT_HELP string = `y - overwrite this file
+n - do not overwrite this file
+a - overwrite this file and all remaining files
+d - do not overwrite this file or any remaining file
+s - show the changes to this file
+q - quit; do not overwrite this file or any remaining file, and stop
+? - print help
+`
+
+//overwritePrompt asks about every existing file before it is overwritten, in
+//the style of git add -p. It reads answers from input and writes to output, so
+//that it does not depend on a terminal.
+type overwritePrompt struct {
+	input  *bufio.Reader
+	output io.Writer
+	//set once 'a' or 'd' answered for all remaining files
+	answeredAll bool
+	overwrite   bool
+}
+
+func newOverwritePrompt(input io.Reader, output io.Writer) *overwritePrompt {
+	return &overwritePrompt{input: bufio.NewReader(input), output: output}
+}
+
+//ask returns true if planned should overwrite the existing file. Quitting, or
+//running out of input before an answer, returns a UserDeclinedError.
+func (self *overwritePrompt) ask(planned plannedFile) (bool, error) {
+	if self.answeredAll {
+		return self.overwrite, nil
+	}
+	for {
+		fmt.Fprintf(self.output, "The file %s already exists. Overwrite? [y,n,a,d,s,q,?] : ", planned.Path)
+		answer, err := self.input.ReadString('\n')
+		if err == io.EOF && answer == "" {
+			fmt.Fprintln(self.output)
+			return false, newLevoError(UserDeclinedError, "Overwriting "+planned.Path+" needs confirmation, but the input ended before an answer")
+		}
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		case "a":
+			self.answeredAll, self.overwrite = true, true
+			return true, nil
+		case "d":
+			self.answeredAll, self.overwrite = true, false
+			return false, nil
+		case "s":
+			fmt.Fprint(self.output, unifiedDiff(planned.Path, planned.Existing, planned.Contents, planned.ExistingMode, planned.Mode))
+		case "q":
+			return false, newLevoError(UserDeclinedError, "Quit before overwriting "+planned.Path)
+		default:
+			fmt.Fprint(self.output, PROMPT_HELP)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOverwritePrompt(testing *testing.T) {
+	planned := plannedFile{Path: "Cats.java", Existing: []byte("class Cats {}\n"), Contents: []byte("class Cats { int legs; }\n"), Status: FileOverwritten}
+	output := bytes.Buffer{}
+
+	prompt := newOverwritePrompt(strings.NewReader("y\nn\nN\n"), &output)
+	for _, expected := range []bool{true, false, false} {
+		if overwrite, err := prompt.ask(planned); err != nil || overwrite != expected {
+			testing.Errorf("Expected %v. Got %v (%v)", expected, overwrite, err)
+		}
+	}
+
+	//help and diff ask again
+	output.Reset()
+	prompt = newOverwritePrompt(strings.NewReader("what\ns\ny\n"), &output)
+	if overwrite, err := prompt.ask(planned); err != nil || !overwrite {
+		testing.Errorf("Expected to overwrite after the help and the diff. Got %v (%v)", overwrite, err)
+	}
+	if !strings.Contains(output.String(), "q - quit") || !strings.Contains(output.String(), "+class Cats { int legs; }") {
+		testing.Errorf("Help or diff were not shown:\n%s", output.String())
+	}
+
+	//all and none answer for every remaining file
+	prompt = newOverwritePrompt(strings.NewReader("a\n"), &output)
+	for i := 0; i < 3; i++ {
+		if overwrite, err := prompt.ask(planned); err != nil || !overwrite {
+			testing.Errorf("Expected all remaining files to be overwritten. Got %v (%v)", overwrite, err)
+		}
+	}
+	prompt = newOverwritePrompt(strings.NewReader("d\n"), &output)
+	for i := 0; i < 3; i++ {
+		if overwrite, err := prompt.ask(planned); err != nil || overwrite {
+			testing.Errorf("Expected no remaining file to be overwritten. Got %v (%v)", overwrite, err)
+		}
+	}
+
+	prompt = newOverwritePrompt(strings.NewReader("q\n"), &output)
+	if _, err := prompt.ask(planned); !isErrorKind(err, UserDeclinedError) {
+		testing.Errorf("Expected quitting to decline. Got %v", err)
+	}
+
+	//running out of input declines rather than prompting endlessly
+	prompt = newOverwritePrompt(strings.NewReader("what"), &output)
+	if _, err := prompt.ask(planned); !isErrorKind(err, UserDeclinedError) || !strings.Contains(err.Error(), "Cats.java needs confirmation") {
+		testing.Errorf("Expected running out of input to decline for Cats.java. Got %v", err)
+	}
+	prompt = newOverwritePrompt(strings.NewReader(""), &output)
+	if _, err := prompt.ask(planned); !isErrorKind(err, UserDeclinedError) {
+		testing.Errorf("Expected closed input to decline. Got %v", err)
+	}
+}
+
+func TestQuitAtOverwritePrompt(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-prompt")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	input, err := ioutil.TempFile("", "levo-stdin")
+	if err != nil {
+		testing.Fatalf("Could not create a temporary file (not a code failure): %v", err.Error())
+	}
+	defer os.Remove(input.Name())
+	input.WriteString("y\nq\n")
+	input.Seek(0, 0)
+	stdin := os.Stdin
+	os.Stdin = input
+	defer func() { os.Stdin = stdin }()
+
+	generatedFiles[0].Body = []byte("class Cats { int legs; }\n")
+	generatedFiles[1].Body = []byte("class Dogs { int legs; }\n")
+	err = outputFiles(generatedFiles)
+	if !isErrorKind(err, UserDeclinedError) {
+		testing.Fatalf("Expected quitting to decline. Got %v", err)
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "Dogs.java"))
+	if string(contents) != "class Dogs {}\n" {
+		testing.Errorf("A file was overwritten after quitting")
+	}
+	manifest, _, _ := readManifest()
+	if len(manifest.Files) != 2 || manifest.Files[0].Hash != contentHash([]byte("class Cats { int legs; }\n")) {
+		testing.Errorf("The manifest does not reflect the files written before quitting: %v", manifest.Files)
+	}
+}
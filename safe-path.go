@@ -0,0 +1,126 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"github.com/cfmobile/levolib"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//sanitizeGeneratedPath normalizes the directory and file name a template gave
+//a generated file, and returns its path relative to the output root. Templates
+//may come from third party repos, so a path that is absolute or climbs out of
+//the root with '..' is an error.
+func sanitizeGeneratedPath(generatedFile levo.GeneratedFile) (string, error) {
+	joined := filepath.Join(generatedFile.Directory, generatedFile.FileName)
+	if strings.TrimSpace(generatedFile.FileName) == "" || joined == "." {
+		return "", errors.New("A file generated in '" + generatedFile.Directory + "' has no file name")
+	}
+	if filepath.IsAbs(joined) || filepath.VolumeName(joined) != "" {
+		return "", errors.New(joined + " is an absolute path")
+	}
+	if isOutsidePath(joined) {
+		return "", errors.New(joined + " is outside of the output directory")
+	}
+	return joined, nil
+}
+
+//resolveGeneratedPath returns where generatedFile is written. Paths that
+//escape the output root, including through a symbolic link inside it, are
+//refused unless -allow-escape is set.
+func resolveGeneratedPath(generatedFile levo.GeneratedFile) (string, error) {
+	relative, err := sanitizeGeneratedPath(generatedFile)
+	if err != nil {
+		if !allowEscape || strings.TrimSpace(generatedFile.FileName) == "" {
+			return "", err
+		}
+		return rootPath(filepath.Join(generatedFile.Directory, generatedFile.FileName)), nil
+	}
+	path := rootPath(relative)
+	if !allowEscape {
+		if err := checkSymlinkEscape(path); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+//generatedDirectory is the directory the template gave generatedFile, cleaned
+//and under the output root. Only it is created when the file is written, not
+//directories in the file name itself.
+func generatedDirectory(generatedFile levo.GeneratedFile) string {
+	return rootPath(filepath.Clean(generatedFile.Directory))
+}
+
+//checkRecordedPath confines a path levo recorded in its state, relative to the
+//output root, the same way as generated files. The state can be edited by hand
+//or checked in, so it is no more trusted than a template.
+func checkRecordedPath(recorded string) error {
+	_, err := resolveGeneratedPath(levo.GeneratedFile{FileName: recorded})
+	return err
+}
+
+//checkSymlinkEscape makes sure the deepest part of path that already exists
+//still resolves to somewhere inside the output root
+func checkSymlinkEscape(path string) error {
+	root, err := filepath.EvalSymlinks(outputRoot())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	for existing := path; ; existing = filepath.Dir(existing) {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if os.IsNotExist(err) && filepath.Dir(existing) != existing {
+			continue
+		} else if err != nil {
+			return err
+		}
+		resolved, err = filepath.Abs(resolved)
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(root, resolved)
+		if err != nil || isOutsidePath(relative) {
+			return errors.New(path + " resolves to " + resolved + ", outside of the output directory")
+		}
+		return nil
+	}
+}
+
+//isOutsidePath is true if a cleaned relative path climbs out of where it is
+//relative to
+func isOutsidePath(relativePath string) bool {
+	return relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+//archiveEntryName is the name of generatedFile inside an archive. Entries are
+//always confined to the archive, since tools that extract it would otherwise
+//write outside of the directory they extract into.
+func archiveEntryName(generatedFile levo.GeneratedFile) (string, error) {
+	relative, err := sanitizeGeneratedPath(generatedFile)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(relative), nil
+}
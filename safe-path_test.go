@@ -0,0 +1,123 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeGeneratedPath(testing *testing.T) {
+	confined := map[string]levo.GeneratedFile{
+		"src/models/Cats.java": levo.GeneratedFile{Directory: "src/models/", FileName: "Cats.java"},
+		"src/Cats.java":        levo.GeneratedFile{Directory: "./src/models/..", FileName: "Cats.java"},
+		"res/values/cats.xml":  levo.GeneratedFile{Directory: "res", FileName: "values/cats.xml"},
+		"Cats.java":            levo.GeneratedFile{FileName: "Cats.java"},
+		"src/models/User.java": levo.GeneratedFile{Directory: "src//models", FileName: "User.java"},
+	}
+	for expected, generatedFile := range confined {
+		path, err := sanitizeGeneratedPath(generatedFile)
+		if err != nil || path != filepath.FromSlash(expected) {
+			testing.Errorf("Expected %v. Got %v (%v)", expected, path, err)
+		}
+	}
+
+	escaping := []levo.GeneratedFile{
+		levo.GeneratedFile{Directory: "../../etc", FileName: "passwd"},
+		levo.GeneratedFile{Directory: "src", FileName: "../../Cats.java"},
+		levo.GeneratedFile{Directory: "/tmp/x", FileName: "Cats.java"},
+		levo.GeneratedFile{Directory: "src", FileName: ""},
+		levo.GeneratedFile{Directory: "src", FileName: ".."},
+	}
+	for _, generatedFile := range escaping {
+		if path, err := sanitizeGeneratedPath(generatedFile); err == nil {
+			testing.Errorf("No error for %v/%v. Got %v", generatedFile.Directory, generatedFile.FileName, path)
+		}
+	}
+}
+
+func TestResolveGeneratedPath(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-safe-path")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", filepath.Join(outputDir, "app"))
+	os.MkdirAll(filepath.Join(outputDir, "app"), 0755)
+
+	escaping := []levo.GeneratedFile{levo.GeneratedFile{Directory: "..", FileName: "Cats.java", Body: []byte("class Cats {}\n")}}
+	if _, err := planOutput(escaping); err == nil {
+		testing.Errorf("No error when planning a file outside of the output directory")
+	}
+	if err := writeZipFile(escaping); err == nil {
+		testing.Errorf("No error when zipping a file outside of the output directory")
+	}
+
+	//a symbolic link inside the root does not let files out
+	if err := os.Symlink(outputDir, filepath.Join(outputDir, "app", "link")); err != nil {
+		testing.Fatalf("Could not create a symbolic link (not a code failure): %v", err.Error())
+	}
+	_, err := resolveGeneratedPath(levo.GeneratedFile{Directory: "link/src", FileName: "Cats.java"})
+	if err == nil {
+		testing.Errorf("No error when writing through a symbolic link that leaves the output directory")
+	}
+
+	//unless escaping is allowed
+	flag.Set("allow-escape", "true")
+	path, err := resolveGeneratedPath(escaping[0])
+	if err != nil || path != filepath.Join(outputDir, "Cats.java") {
+		testing.Errorf("Escaping path was not allowed with -allow-escape. Got %v (%v)", path, err)
+	}
+	path, err = resolveGeneratedPath(levo.GeneratedFile{Directory: outputDir, FileName: "Dogs.java"})
+	if err != nil || path != filepath.Join(outputDir, "Dogs.java") {
+		testing.Errorf("Absolute path was not allowed with -allow-escape. Got %v (%v)", path, err)
+	}
+	if err := writeZipFile(escaping); err == nil {
+		testing.Errorf("Zip entries escaped the archive with -allow-escape")
+	}
+}
+
+func TestOutputCreatesSanitizedDirectories(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-safe-path")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	//a package left empty renders as src//models
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{Directory: "lib/models/..", FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{Directory: "src//models", FileName: "User.java", Body: []byte("class User {}\n")},
+	}
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "lib", "Cats.java")); err != nil {
+		testing.Errorf("File was not written where its path leads: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "src", "models", "User.java")); err != nil {
+		testing.Errorf("File in a directory with an empty name in between was not written: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "lib", "models")); !os.IsNotExist(err) {
+		testing.Errorf("The directory a template gave was created as is, instead of the one the file is written to")
+	}
+}
+
+func TestArchiveEntryName(testing *testing.T) {
+	name, err := archiveEntryName(levo.GeneratedFile{Directory: "src/models", FileName: "Cats.java"})
+	if err != nil || name != "src/models/Cats.java" {
+		testing.Errorf("Unexpected zip entry name %v (%v)", name, err)
+	}
+}
@@ -0,0 +1,157 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+//What to do with files an earlier run generated that the current run no
+//longer produces, e.g. because their model was removed from the schema
+const STALE_REPORT string = "report"
+const STALE_DELETE string = "delete"
+const STALE_IGNORE string = "ignore"
+
+func validStalePolicy(policy string) bool {
+	return policy == STALE_REPORT || policy == STALE_DELETE || policy == STALE_IGNORE
+}
+
+//findStaleFiles returns the files of the previous run with the same inputs
+//that are not part of plan. Files generated with other inputs are never stale.
+func findStaleFiles(previous generationManifest, plan []plannedFile) []manifestEntry {
+	planned := make(map[string]bool)
+	for _, file := range plan {
+		planned[relativeToRoot(file.Path)] = true
+	}
+	stale := make([]manifestEntry, 0)
+	for _, entry := range previous.Files {
+		if !planned[entry.Path] {
+			stale = append(stale, entry)
+		}
+	}
+	return stale
+}
+
+//isModifiedSinceGenerated is true if the file on disk no longer matches its manifest entry
+func isModifiedSinceGenerated(entry manifestEntry) (bool, error) {
+	contents, err := ioutil.ReadFile(rootPath(entry.Path))
+	if err != nil {
+		return false, err
+	}
+	return contentHash(contents) != entry.Hash, nil
+}
+
+//handleStaleFiles applies the stale policy to the files the previous run
+//generated that this run did not. Stale files that are kept stay in the new
+//manifest so that a later run or 'levo clean' can still remove them. Files
+//changed by hand are never deleted unless -quiet is set.
+func handleStaleFiles(plan []plannedFile, manifest *generationManifest, undo *undoLog) error {
+	previous, found, err := readPreviousRun()
+	if err != nil || !found {
+		return err
+	}
+	keepDeclinedFiles(previous, plan, manifest)
+	for _, entry := range findStaleFiles(previous, plan) {
+		modified, err := isModifiedSinceGenerated(entry)
+		if os.IsNotExist(err) {
+			//removed by the user already
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		path := rootPath(entry.Path)
+		if stalePolicy == STALE_DELETE && (!modified || forceOverwrite) {
+			if err := removeRecordedFile(path, undo); err != nil {
+				return err
+			}
+			if err := removeRecordedFile(generationBasePath(path), undo); err != nil {
+				return err
+			}
+			reportEvent(eventOutput, runEvent{Event: EVENT_FILE, Path: path, Status: "deleted"}, "Deleted stale file "+path)
+			continue
+		}
+
+		manifest.Files = append(manifest.Files, entry)
+		if stalePolicy == STALE_DELETE {
+			reportWarning(path + " is no longer generated but was changed since, and was not deleted")
+		} else if stalePolicy == STALE_REPORT {
+			reportWarning(path + " is no longer generated. Use -stale delete to remove it")
+		}
+	}
+	return nil
+}
+
+//keepDeclinedFiles carries over the manifest entries of the files the user
+//declined to overwrite, since they still hold what an earlier run generated
+func keepDeclinedFiles(previous generationManifest, plan []plannedFile, manifest *generationManifest) {
+	planned := make(map[string]bool)
+	for _, file := range plan {
+		planned[relativeToRoot(file.Path)] = true
+	}
+	recorded := make(map[string]bool)
+	for _, entry := range manifest.Files {
+		recorded[entry.Path] = true
+	}
+	for _, entry := range previous.Files {
+		if planned[entry.Path] && !recorded[entry.Path] {
+			manifest.Files = append(manifest.Files, entry)
+		}
+	}
+}
+
+//existingStaleFiles returns the stale files that are still on disk, and
+//whether each of them was changed since it was generated
+func existingStaleFiles(plan []plannedFile) ([]manifestEntry, []bool, error) {
+	previous, found, err := readPreviousRun()
+	if err != nil || !found {
+		return []manifestEntry{}, []bool{}, err
+	}
+	existing := make([]manifestEntry, 0)
+	modifications := make([]bool, 0)
+	for _, entry := range findStaleFiles(previous, plan) {
+		modified, err := isModifiedSinceGenerated(entry)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return []manifestEntry{}, []bool{}, err
+		}
+		existing = append(existing, entry)
+		modifications = append(modifications, modified)
+	}
+	return existing, modifications, nil
+}
+
+//printStaleFiles lists the stale files in the format of printOutputPlan
+func printStaleFiles(plan []plannedFile, writer io.Writer) error {
+	if stalePolicy == STALE_IGNORE {
+		return nil
+	}
+	stale, modifications, err := existingStaleFiles(plan)
+	if err != nil {
+		return err
+	}
+	for i, entry := range stale {
+		action := "stale"
+		if stalePolicy == STALE_DELETE && (!modifications[i] || forceOverwrite) {
+			action = "delete"
+		}
+		reportEvent(writer, runEvent{Event: EVENT_FILE, Path: rootPath(entry.Path), Status: action}, fmt.Sprintf("%-9s %16s%s", action, "", rootPath(entry.Path)))
+	}
+	return nil
+}
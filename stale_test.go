@@ -0,0 +1,128 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStaleFiles(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-stale")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	cats := levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")}
+	dogs := levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")}
+	birds := levo.GeneratedFile{FileName: "Birds.java", Body: []byte("class Birds {}\n")}
+	if err := outputFiles([]levo.GeneratedFile{cats, dogs, birds}); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	ioutil.WriteFile(filepath.Join(outputDir, "Birds.java"), []byte("class Birds { int wings; }\n"), 0644)
+
+	//by default stale files are only reported, and stay in the manifest
+	flag.Set("quiet", "true")
+	if err := outputFiles([]levo.GeneratedFile{cats}); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dogs.java")); err != nil {
+		testing.Errorf("A stale file was deleted when only reporting")
+	}
+	manifest, _, _ := readManifest()
+	if len(manifest.Files) != 3 {
+		testing.Errorf("Expected the stale files to stay in the manifest. Got %v", manifest.Files)
+	}
+
+	plan, _ := planOutput([]levo.GeneratedFile{cats})
+	writer := bytes.Buffer{}
+	flag.Set("quiet", "false")
+	flag.Set("stale", STALE_DELETE)
+	if err := printStaleFiles(plan, &writer); err != nil {
+		testing.Fatalf("Error listing stale files: %v", err.Error())
+	}
+	if !strings.Contains(writer.String(), "delete") || !strings.Contains(writer.String(), "Dogs.java") || !strings.Contains(writer.String(), "stale") {
+		testing.Errorf("Unexpected stale file listing:\n%s", writer.String())
+	}
+
+	//only unchanged files are deleted
+	os.Remove(filepath.Join(outputDir, "Cats.java"))
+	if err := outputFiles([]levo.GeneratedFile{cats}); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dogs.java")); !os.IsNotExist(err) {
+		testing.Errorf("A stale file was not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Birds.java")); err != nil {
+		testing.Errorf("A stale file changed by the user was deleted")
+	}
+	manifest, _, _ = readManifest()
+	if len(manifest.Files) != 2 {
+		testing.Errorf("Expected the deleted file to leave the manifest. Got %v", manifest.Files)
+	}
+}
+
+func TestStaleFilesOfOtherInputs(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-stale")
+	defer os.RemoveAll(outputDir)
+	generate := func(model string, generatedFiles []levo.GeneratedFile) {
+		resetFlags()
+		flag.Set("out", outputDir)
+		flag.Set("template", "m.lt")
+		flag.Set("model", model)
+		flag.Set("quiet", "true")
+		flag.Set("stale", STALE_DELETE)
+		if err := outputFiles(generatedFiles); err != nil {
+			testing.Fatalf("Error writing files: %v", err.Error())
+		}
+	}
+	user := levo.GeneratedFile{FileName: "User.java", Body: []byte("class User {}\n")}
+	dog := levo.GeneratedFile{FileName: "Dog.java", Body: []byte("class Dog {}\n")}
+
+	generate(`{"Name": "User"}`, []levo.GeneratedFile{user})
+	generate(`{"Name": "Dog"}`, []levo.GeneratedFile{dog})
+	if _, err := os.Stat(filepath.Join(outputDir, "User.java")); err != nil {
+		testing.Errorf("A file generated with other models was deleted as stale")
+	}
+	plan, _ := planOutput([]levo.GeneratedFile{dog})
+	if stale, _, err := existingStaleFiles(plan); err != nil || len(stale) != 0 {
+		testing.Errorf("Files generated with other models were seen as stale: %v %v", stale, err)
+	}
+
+	//the runs are told apart by their inputs, so each still finds its own stale files
+	generate(`{"Name": "User"}`, []levo.GeneratedFile{})
+	if _, err := os.Stat(filepath.Join(outputDir, "User.java")); !os.IsNotExist(err) {
+		testing.Errorf("A stale file of the same inputs was not deleted")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dog.java")); err != nil {
+		testing.Errorf("A file generated with other models was deleted")
+	}
+
+	if err := cleanGeneratedFiles(); err != nil {
+		testing.Fatalf("Error cleaning generated files: %v", err.Error())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Dog.java")); !os.IsNotExist(err) {
+		testing.Errorf("Clean did not remove the files of every run")
+	}
+}
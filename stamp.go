@@ -0,0 +1,248 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/cfmobile/levolib"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//The first and last line of a provenance stamp. Everything in between is
+//informational, only the checksum is read back.
+const STAMP_FIRST_LINE string = "Generated by levo "
+const STAMP_CHECKSUM_LINE string = "levo-checksum: "
+
+//stampCommentSyntax holds the line comment, or the opening and closing of a
+//block comment, for the extensions levo knows how to stamp. Files with any
+//other extension, and formats without comments such as JSON, are not stamped.
+var stampCommentSyntax = map[string][2]string{
+	".java":       {"// ", ""},
+	".kt":         {"// ", ""},
+	".go":         {"// ", ""},
+	".swift":      {"// ", ""},
+	".m":          {"// ", ""},
+	".h":          {"// ", ""},
+	".c":          {"// ", ""},
+	".cpp":        {"// ", ""},
+	".cs":         {"// ", ""},
+	".js":         {"// ", ""},
+	".ts":         {"// ", ""},
+	".scala":      {"// ", ""},
+	".groovy":     {"// ", ""},
+	".gradle":     {"// ", ""},
+	".dart":       {"// ", ""},
+	".rs":         {"// ", ""},
+	".rb":         {"# ", ""},
+	".py":         {"# ", ""},
+	".sh":         {"# ", ""},
+	".yml":        {"# ", ""},
+	".yaml":       {"# ", ""},
+	".properties": {"# ", ""},
+	".toml":       {"# ", ""},
+	".sql":        {"-- ", ""},
+	".lua":        {"-- ", ""},
+	".css":        {"/* ", " */"},
+	".xml":        {"<!-- ", " -->"},
+	".html":       {"<!-- ", " -->"},
+	".plist":      {"<!-- ", " -->"},
+	".storyboard": {"<!-- ", " -->"},
+	".xib":        {"<!-- ", " -->"},
+}
+
+//provenance describes where the files of a run come from. It is the same for
+//every file, so that an unchanged input stamps an unchanged file.
+type provenance struct {
+	Template       string
+	TemplateCommit string
+	SchemaHash     string
+}
+
+var runProvenance provenance
+
+//recordProvenance remembers the template and models of this run for -stamp
+func recordProvenance(template string, models []levo.Model) {
+	runProvenance = provenance{Template: templateLabel(template)}
+	if encodedModels, err := json.Marshal(models); err == nil {
+		runProvenance.SchemaHash = shortChecksum(encodedModels)
+	}
+	command := exec.Command("git", "rev-parse", "HEAD")
+	command.Dir = templateDirectory(template)
+	if commit, err := command.Output(); err == nil {
+		runProvenance.TemplateCommit = strings.TrimSpace(string(commit))
+	}
+}
+
+func templateDirectory(template string) string {
+	if fileInfo, err := os.Stat(template); err == nil && !fileInfo.IsDir() {
+		return filepath.Dir(template)
+	}
+	return template
+}
+
+//templateLabel is how a template is named in the stamp. Stamped files are
+//usually checked in, so it must not give away where the templates were on the
+//machine that generated them: templates downloaded to ~/.levo are named by
+//their import path, templates in a git repo relative to the repo root, and any
+//other template outside of the working directory by its name.
+func templateLabel(template string) string {
+	absolutePath, err := filepath.Abs(template)
+	if err != nil {
+		return filepath.Base(template)
+	}
+	if homeDir, err := userHomeDir(); err == nil {
+		if relativePath, err := filepath.Rel(filepath.Join(homeDir, ".levo"), absolutePath); err == nil && !isOutsidePath(relativePath) {
+			return filepath.ToSlash(relativePath)
+		}
+	}
+	command := exec.Command("git", "rev-parse", "--show-toplevel")
+	command.Dir = templateDirectory(template)
+	if topLevel, err := command.Output(); err == nil {
+		realPath, err := filepath.EvalSymlinks(absolutePath)
+		if err != nil {
+			realPath = absolutePath
+		}
+		repoRoot := strings.TrimSpace(string(topLevel))
+		if relativePath, err := filepath.Rel(repoRoot, realPath); err == nil && !isOutsidePath(relativePath) {
+			if relativePath == "." {
+				return filepath.Base(repoRoot)
+			}
+			return filepath.ToSlash(relativePath)
+		}
+	}
+	if workingDirectory, err := os.Getwd(); err == nil {
+		if relativePath, err := filepath.Rel(workingDirectory, absolutePath); err == nil && !isOutsidePath(relativePath) && relativePath != "." {
+			return filepath.ToSlash(relativePath)
+		}
+	}
+	return filepath.Base(absolutePath)
+}
+
+func shortChecksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+//stampFileContents puts the provenance header at the top of contents, after a
+//byte order mark, a shebang or an XML declaration
+func stampFileContents(relativePath string, contents []byte) []byte {
+	syntax, found := stampCommentSyntax[strings.ToLower(filepath.Ext(relativePath))]
+	if !found || isBinaryContents(contents) {
+		return contents
+	}
+	byteOrderMark := ""
+	if bytes.HasPrefix(contents, UTF8_BOM) {
+		byteOrderMark = string(UTF8_BOM)
+		contents = contents[len(UTF8_BOM):]
+	}
+	lines := splitLines(contents)
+	prefix := 0
+	if len(lines) > 0 && (strings.HasPrefix(lines[0], "#!") || strings.HasPrefix(lines[0], "<?xml")) {
+		prefix = 1
+		if !strings.HasSuffix(lines[0], "\n") {
+			lines[0] += "\n"
+		}
+	}
+
+	header := []string{STAMP_FIRST_LINE + LEVO_VERSION + ". Hand edits are detected when it is regenerated"}
+	if runProvenance.Template != "" {
+		template := "template: " + runProvenance.Template
+		if runProvenance.TemplateCommit != "" {
+			template += " @ " + runProvenance.TemplateCommit
+		}
+		header = append(header, template)
+	}
+	if runProvenance.SchemaHash != "" {
+		header = append(header, "schema: "+runProvenance.SchemaHash)
+	}
+	header = append(header, STAMP_CHECKSUM_LINE+stampChecksum(lines))
+
+	stamped := make([]string, 0, len(lines)+len(header)+1)
+	stamped = append(stamped, byteOrderMark)
+	stamped = append(stamped, lines[:prefix]...)
+	for _, line := range header {
+		stamped = append(stamped, syntax[0]+line+syntax[1]+"\n")
+	}
+	stamped = append(stamped, lines[prefix:]...)
+	return []byte(strings.Join(stamped, ""))
+}
+
+//findStamp returns the indexes of the first and last line of the stamp in
+//lines, and the checksum it holds
+func findStamp(lines []string) (int, int, string, bool) {
+	for start := 0; start < len(lines) && start < 2; start++ {
+		if !strings.Contains(lines[start], STAMP_FIRST_LINE) {
+			continue
+		}
+		for end := start + 1; end < len(lines) && end < start+8; end++ {
+			if i := strings.Index(lines[end], STAMP_CHECKSUM_LINE); i >= 0 {
+				fields := strings.Fields(lines[end][i+len(STAMP_CHECKSUM_LINE):])
+				if len(fields) == 0 {
+					return 0, 0, "", false
+				}
+				return start, end, fields[0], true
+			}
+		}
+	}
+	return 0, 0, "", false
+}
+
+//stampChecksum hashes the lines of a file without its stamp. What is inside
+//user code regions belongs to the user, so it is left out as well. Files are
+//normalized after they are stamped, so line endings, a byte order mark and
+//whitespace at the end of the file do not count either.
+func stampChecksum(lines []string) string {
+	if start, end, _, found := findStamp(lines); found {
+		lines = append(append([]string{}, lines[:start]...), lines[end+1:]...)
+	}
+	generatedLines := lines
+	if regions, err := findUserCodeRegions(lines); err == nil && len(regions) > 0 {
+		generatedLines = make([]string, 0, len(lines))
+		previousEnd := 0
+		for _, region := range regions {
+			generatedLines = append(generatedLines, lines[previousEnd:region.Start+1]...)
+			previousEnd = region.End
+		}
+		generatedLines = append(generatedLines, lines[previousEnd:]...)
+	}
+	text := strings.TrimPrefix(strings.Join(generatedLines, ""), string(UTF8_BOM))
+	text = strings.TrimRight(strings.Replace(text, "\r\n", "\n", -1), " \t\r\n")
+	return shortChecksum([]byte(text))
+}
+
+//isHandEdited is true if contents carry a stamp that no longer matches them
+func isHandEdited(contents []byte) bool {
+	lines := splitLines(contents)
+	_, _, checksum, found := findStamp(lines)
+	return found && checksum != stampChecksum(lines)
+}
+
+//refreshStamp updates the checksum of stamped contents that levo itself
+//changed, e.g. by injecting a snippet
+func refreshStamp(contents []byte) []byte {
+	lines := splitLines(contents)
+	_, end, checksum, found := findStamp(lines)
+	if !found {
+		return contents
+	}
+	lines[end] = strings.Replace(lines[end], STAMP_CHECKSUM_LINE+checksum, STAMP_CHECKSUM_LINE+stampChecksum(lines), 1)
+	return []byte(strings.Join(lines, ""))
+}
@@ -0,0 +1,152 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStampFileContents(testing *testing.T) {
+	defer func() { runProvenance = provenance{} }()
+	runProvenance = provenance{Template: "templates/_Name_.lt", TemplateCommit: "0123abc", SchemaHash: "feedbeef"}
+
+	stamped := string(stampFileContents("src/Cats.java", []byte("class Cats {}\n")))
+	if !strings.HasPrefix(stamped, "// "+STAMP_FIRST_LINE+LEVO_VERSION) || !strings.Contains(stamped, "// template: templates/_Name_.lt @ 0123abc\n// schema: feedbeef\n// "+STAMP_CHECKSUM_LINE) {
+		testing.Errorf("Unexpected stamp:\n%s", stamped)
+	}
+	if !strings.HasSuffix(stamped, "\nclass Cats {}\n") || isHandEdited([]byte(stamped)) {
+		testing.Errorf("Freshly stamped file is seen as edited:\n%s", stamped)
+	}
+	if stamped != string(stampFileContents("src/Cats.java", []byte("class Cats {}\n"))) {
+		testing.Errorf("Stamping the same contents twice gave different stamps")
+	}
+	if !isHandEdited([]byte(strings.Replace(stamped, "Cats {}", "Cats { int legs; }", 1))) {
+		testing.Errorf("Hand edit was not detected")
+	}
+	if isHandEdited([]byte("class Cats {}\n")) {
+		testing.Errorf("File without a stamp is seen as edited")
+	}
+
+	script := string(stampFileContents("run.sh", []byte("#!/bin/sh\necho cats\n")))
+	if !strings.HasPrefix(script, "#!/bin/sh\n# "+STAMP_FIRST_LINE) || isHandEdited([]byte(script)) {
+		testing.Errorf("Stamp did not follow the shebang:\n%s", script)
+	}
+	layout := string(stampFileContents("cats.xml", []byte("<?xml version=\"1.0\"?>\n<cats/>\n")))
+	if !strings.Contains(layout, "?>\n<!-- "+STAMP_FIRST_LINE) || !strings.Contains(layout, " -->\n<cats/>") {
+		testing.Errorf("XML was not stamped with a block comment:\n%s", layout)
+	}
+	if string(stampFileContents("cats.json", []byte("{}\n"))) != "{}\n" {
+		testing.Errorf("File without comments was stamped")
+	}
+
+	withBOM := stampFileContents("Cats.java", append(append([]byte{}, UTF8_BOM...), "class Cats {}\n"...))
+	if !strings.HasPrefix(string(withBOM), string(UTF8_BOM)+"// "+STAMP_FIRST_LINE) || strings.Count(string(withBOM), string(UTF8_BOM)) != 1 || isHandEdited(withBOM) {
+		testing.Errorf("Stamp did not follow the byte order mark:\n%q", withBOM)
+	}
+	normalization = outputNormalization{ByteOrderMark: BOM_REMOVE}
+	defer func() { normalization = outputNormalization{} }()
+	if withoutBOM := normalizeFileContents("Cats.java", withBOM); bytes.Contains(withoutBOM, UTF8_BOM) || isHandEdited(withoutBOM) {
+		testing.Errorf("Byte order mark was not removed from a stamped file:\n%q", withoutBOM)
+	}
+}
+
+func TestRecordProvenanceTemplateLabel(testing *testing.T) {
+	defer func() { runProvenance = provenance{} }()
+	repoDir := testTempDir(testing, "levo-provenance")
+	defer os.RemoveAll(repoDir)
+	templatesDir := filepath.Join(repoDir, "templates", "android")
+	os.MkdirAll(templatesDir, 0755)
+
+	recordProvenance(templatesDir, []levo.Model{})
+	if runProvenance.Template != "android" {
+		testing.Errorf("Template outside of a repo was recorded as %v", runProvenance.Template)
+	}
+	if err := exec.Command("git", "init", "-q", repoDir).Run(); err != nil {
+		testing.Skipf("Could not create a git repo (not a code failure): %v", err.Error())
+	}
+	recordProvenance(templatesDir, []levo.Model{})
+	if runProvenance.Template != "templates/android" {
+		testing.Errorf("Template in a repo was not recorded relative to its root: %v", runProvenance.Template)
+	}
+
+	homeDir, err := userHomeDir()
+	if err != nil {
+		testing.Fatalf("Could not find the home directory (not a code failure): %v", err.Error())
+	}
+	recordProvenance(filepath.Join(homeDir, ".levo", "github.com", "cfmobile", "templates", "android"), []levo.Model{})
+	if runProvenance.Template != "github.com/cfmobile/templates/android" {
+		testing.Errorf("Downloaded template was not recorded by its import path: %v", runProvenance.Template)
+	}
+}
+
+func TestStampIgnoresUserCode(testing *testing.T) {
+	body := "class Cats {\n// levo:begin-user-code fields\n// levo:end-user-code\n}\n"
+	stamped := string(stampFileContents("Cats.java", []byte(body)))
+	withUserCode := strings.Replace(stamped, "fields\n", "fields\n\tint legs;\n", 1)
+	if isHandEdited([]byte(withUserCode)) {
+		testing.Errorf("Code in a user code region was seen as a hand edit")
+	}
+	outsideUserCode := strings.Replace(withUserCode, "class Cats", "class Dogs", 1)
+	if !isHandEdited([]byte(outsideUserCode)) {
+		testing.Errorf("Hand edit outside of the user code regions was not detected")
+	}
+	if isHandEdited(refreshStamp([]byte(outsideUserCode))) {
+		testing.Errorf("Refreshed stamp does not match the contents")
+	}
+}
+
+func TestResolveAsksAboutHandEdits(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-stamp")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("quiet", "true")
+	flag.Set("stamp", "true")
+
+	if err := outputFiles([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")}}); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	path := filepath.Join(outputDir, "Cats.java")
+	contents, _ := ioutil.ReadFile(path)
+	if !isHandEdited(bytes.Replace(contents, []byte("{}"), []byte("{ }"), 1)) {
+		testing.Fatalf("Written file was not stamped:\n%s", contents)
+	}
+
+	generatedFiles := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats { int legs; }\n")}}
+	plan, _ := planOutput(generatedFiles)
+	if plan[0].HandEdited {
+		testing.Errorf("Regenerated file that was not edited is seen as edited")
+	}
+
+	ioutil.WriteFile(path, bytes.Replace(contents, []byte("{}"), []byte("{ int tails; }"), 1), 0644)
+	plan, _ = planOutput(generatedFiles)
+	if !plan[0].HandEdited || len(plan[0].Warnings) != 1 {
+		testing.Fatalf("Hand edit was not detected when planning")
+	}
+	output := bytes.Buffer{}
+	resolver := conflictResolver{prompt: newOverwritePrompt(strings.NewReader("n\n"), &output)}
+	write, err := resolver.resolve(plan[0], nil)
+	if err != nil || write || !strings.Contains(output.String(), "Overwrite?") {
+		testing.Errorf("Hand edited file was overwritten without asking, even with -quiet: %v %v", write, err)
+	}
+}
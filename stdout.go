@@ -0,0 +1,59 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cfmobile/levolib"
+	"io"
+)
+
+//OUTPUT_STDOUT as the output directory does the same as -stdout
+const OUTPUT_STDOUT string = "-"
+
+func writesToStdout() bool {
+	return stdoutOutput || outputDirectory == OUTPUT_STDOUT
+}
+
+//writeToStdout writes the generated files to writer instead of to disk. A
+//single file is written as is, so that it can be piped into another tool.
+//Several files are each preceded by a "==> path <==" line, as head and tail do.
+//Files are rendered, and streamed, the same way as for an archive.
+func writeToStdout(generatedFiles []levo.GeneratedFile, writer io.Writer) error {
+	files, err := collectOutputFiles(generatedFiles, archiveEntryName)
+	if err != nil {
+		return err
+	}
+	written := 0
+	return eachArchiveEntry(files, func(entry archiveEntry) error {
+		written++
+		if len(files) == 1 {
+			_, err := writer.Write(entry.Contents)
+			return err
+		}
+		if written > 1 {
+			fmt.Fprintln(writer)
+		}
+		fmt.Fprintf(writer, "==> %s <==\n", entry.Name)
+		if _, err := writer.Write(entry.Contents); err != nil {
+			return err
+		}
+		if len(entry.Contents) > 0 && !bytes.HasSuffix(entry.Contents, []byte("\n")) {
+			fmt.Fprintln(writer)
+		}
+		return nil
+	})
+}
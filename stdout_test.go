@@ -0,0 +1,72 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestWriteToStdout(testing *testing.T) {
+	output := bytes.Buffer{}
+	err := writeToStdout([]levo.GeneratedFile{levo.GeneratedFile{FileName: "User.java", Directory: "src", Body: []byte("class User {}")}}, &output)
+	if err != nil || output.String() != "class User {}" {
+		testing.Errorf("A single file was not written as is: %q %v", output.String(), err)
+	}
+
+	output.Reset()
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "User.java", Directory: "src", Body: []byte("class User {}")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("// routes\n")},
+		levo.GeneratedFile{FileName: "Routes.java", Body: []byte("<<levoinject after:routes>>\nroutes.add(User.class);\n")},
+	}
+	err = writeToStdout(generatedFiles, &output)
+	expected := "==> src/User.java <==\nclass User {}\n\n==> Routes.java <==\n// routes\nroutes.add(User.class);\n"
+	if err != nil || output.String() != expected {
+		testing.Errorf("Expected:\n%s\nGot:\n%s", expected, output.String())
+	}
+}
+
+func TestOutputToStdoutWritesNoFiles(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	output, err := ioutil.TempFile("", "levo-stdout")
+	if err != nil {
+		testing.Fatalf("Could not create a temporary file (not a code failure): %v", err.Error())
+	}
+	defer os.Remove(output.Name())
+	flag.Set("out", OUTPUT_STDOUT)
+
+	stdout := os.Stdout
+	os.Stdout = output
+	err = writeGeneratedFiles([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.generic", Body: []byte("cats\n")}})
+	os.Stdout = stdout
+	output.Close()
+	if err != nil {
+		testing.Fatalf("Error writing to stdout: %v", err.Error())
+	}
+	if contents, _ := ioutil.ReadFile(output.Name()); string(contents) != "cats\n" {
+		testing.Errorf("Stdout did not receive the file: %q", contents)
+	}
+	for _, path := range []string{"Cats.generic", OUTPUT_STDOUT, LEVO_STATE_DIRECTORY} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			testing.Errorf("Writing to stdout created %v", path)
+		}
+	}
+}
@@ -31,18 +31,18 @@ func addTemplatePath(context *levo.Context, templatePath string) ([]levo.Templat
 
 	fileInfo, err := os.Stat(templatePath)
 	if err != nil {
-		return []levo.TemplateInfo{}, err
+		return []levo.TemplateInfo{}, wrapLevoError(TemplateError, "", err)
 	}
 
 	if fileInfo.IsDir() {
 		templates, err = context.AddTemplateDirectory(templatePath)
 		if err != nil {
-			return []levo.TemplateInfo{}, err
+			return []levo.TemplateInfo{}, wrapLevoError(TemplateError, "", err)
 		}
 	} else {
 		templateObj, err := context.AddTemplateFilePath(templatePath)
 		if err != nil {
-			return []levo.TemplateInfo{}, err
+			return []levo.TemplateInfo{}, wrapLevoError(TemplateError, "", err)
 		}
 		templateObj.Directory = "" //User gave us a path to template. Assume no directory information
 		templates = make([]levo.TemplateInfo, 0)
@@ -56,7 +56,7 @@ func getUpdatedTemplateRepo(templatePath string) (string, error) {
 		templatePathParts := strings.Split(templatePath, "/")
 		rootPrefix, err := getTemplateRepo(strings.Join(templatePathParts[0:3], "/"))
 		if err != nil {
-			return "", errors.New("Template Repo: " + err.Error())
+			return "", wrapLevoError(TemplateRepoError, "Template Repo: ", err)
 		}
 		templatePath = rootPrefix + templatePath
 	}
@@ -0,0 +1,82 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+//transaction rolls back every file a run touched when the run fails or is
+//interrupted, so that a regeneration either applies fully or not at all. It
+//reuses the undo log of the run, which already backs up every file first.
+type transaction struct {
+	undo       *undoLog
+	interrupts chan os.Signal
+	done       chan bool
+}
+
+func beginTransaction(undo *undoLog) *transaction {
+	self := &transaction{undo: undo, interrupts: make(chan os.Signal, 1), done: make(chan bool)}
+	signal.Notify(self.interrupts, os.Interrupt, syscall.SIGTERM)
+	go self.watchInterrupts()
+	return self
+}
+
+//watchInterrupts rolls back and exits on Ctrl-C. Taking the lock of the undo
+//log waits for the write in progress to finish, and keeps any other from starting.
+func (self *transaction) watchInterrupts() {
+	select {
+	case <-self.interrupts:
+		self.undo.lock.Lock()
+		if err := self.rollback(); err != nil {
+			reportError(newLevoError(OutputError, "Interrupted. Rolling back failed: "+err.Error()))
+			os.Exit(EXIT_OUTPUT)
+		}
+		reportError(newLevoError(UserDeclinedError, "Interrupted. Every file touched by this run was restored"))
+		os.Exit(EXIT_USER_DECLINED)
+	case <-self.done:
+	}
+}
+
+//abort rolls back the run that failed with err, and returns err with the
+//outcome of the rollback appended
+func (self *transaction) abort(err error) error {
+	self.undo.lock.Lock()
+	defer self.undo.lock.Unlock()
+
+	suffix := ". Every file touched by this run was restored"
+	if rollbackErr := self.rollback(); rollbackErr != nil {
+		suffix = ". Rolling back failed too: " + rollbackErr.Error()
+	}
+	if levoErr, ok := err.(LevoError); ok {
+		return LevoError{Kind: levoErr.Kind, Message: levoErr.Message + suffix}
+	}
+	return newLevoError(OutputError, err.Error()+suffix)
+}
+
+func (self *transaction) rollback() error {
+	if err := self.undo.restore(); err != nil {
+		return err
+	}
+	//there is nothing left to undo
+	return os.RemoveAll(backupDirectory())
+}
+
+func (self *transaction) end() {
+	signal.Stop(self.interrupts)
+	close(self.done)
+}
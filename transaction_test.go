@@ -0,0 +1,75 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTransactionRollsBackFailedRun(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-transaction")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("transaction", "true")
+
+	//the overwrite prompt fails when stdin is closed, after Cats.java was written
+	input, err := ioutil.TempFile("", "levo-stdin")
+	if err != nil {
+		testing.Fatalf("Could not create a temporary file (not a code failure): %v", err.Error())
+	}
+	defer os.Remove(input.Name())
+	stdin := os.Stdin
+	os.Stdin = input
+	defer func() { os.Stdin = stdin }()
+
+	ioutil.WriteFile(filepath.Join(outputDir, "Dogs.java"), []byte("class Dogs { int legs; }\n"), 0644)
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Directory: "src", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	err = outputFiles(generatedFiles)
+	if err == nil || !strings.Contains(err.Error(), "restored") {
+		testing.Fatalf("Expected the failed run to be rolled back. Got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "src")); !os.IsNotExist(err) {
+		testing.Errorf("A file created by the failed run was left behind")
+	}
+	contents, _ := ioutil.ReadFile(filepath.Join(outputDir, "Dogs.java"))
+	if string(contents) != "class Dogs { int legs; }\n" {
+		testing.Errorf("An existing file was changed by the failed run: %q", contents)
+	}
+	if _, found, _ := readManifest(); found {
+		testing.Errorf("A manifest was left behind by the failed run")
+	}
+	if err := undoLastRun(); err == nil {
+		testing.Errorf("A rolled back run can still be undone")
+	}
+
+	//without a transaction the files written before the failure stay
+	flag.Set("transaction", "false")
+	if err := outputFiles(generatedFiles); err == nil {
+		testing.Fatalf("Expected the prompt to fail")
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "src", "Cats.java")); err != nil {
+		testing.Errorf("A file written before the failure was rolled back without -transaction")
+	}
+}
@@ -0,0 +1,114 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+//Templates mark regions that belong to the user with a pair of comments, e.g.
+//
+//	// levo:begin-user-code imports
+//	// levo:end-user-code
+//
+//When a file is regenerated, whatever the existing file holds between a pair
+//of markers replaces what the template put between the markers with the same id.
+var userCodeBeginRegex = regexp.MustCompile(`levo:begin-user-code\s+(\S+)`)
+var userCodeEndRegex = regexp.MustCompile(`levo:end-user-code\b`)
+
+//userCodeRegion is the content between one pair of markers. Start and End are
+//the indexes of the marker lines themselves.
+type userCodeRegion struct {
+	Id    string
+	Start int
+	End   int
+}
+
+func findUserCodeRegions(lines []string) ([]userCodeRegion, error) {
+	regions := make([]userCodeRegion, 0)
+	seenIds := make(map[string]bool)
+	var open *userCodeRegion
+	for i, line := range lines {
+		if match := userCodeBeginRegex.FindStringSubmatch(line); match != nil {
+			if open != nil {
+				return []userCodeRegion{}, errors.New("User code region " + match[1] + " starts inside region " + open.Id)
+			}
+			if seenIds[match[1]] {
+				return []userCodeRegion{}, errors.New("User code region " + match[1] + " is defined more than once")
+			}
+			seenIds[match[1]] = true
+			open = &userCodeRegion{Id: match[1], Start: i}
+		} else if userCodeEndRegex.MatchString(line) {
+			if open == nil {
+				return []userCodeRegion{}, errors.New("levo:end-user-code without a matching levo:begin-user-code")
+			}
+			open.End = i
+			regions = append(regions, *open)
+			open = nil
+		}
+	}
+	if open != nil {
+		return []userCodeRegion{}, errors.New("User code region " + open.Id + " is never closed")
+	}
+	return regions, nil
+}
+
+//preserveUserCode copies the user code regions of existingContents into
+//generatedContents. It also returns the ids of regions that held code in the
+//existing file but no longer exist in the generated one, since that code is lost.
+func preserveUserCode(existingContents []byte, generatedContents []byte) ([]byte, []string, error) {
+	existingLines := splitLines(existingContents)
+	existingRegions, err := findUserCodeRegions(existingLines)
+	if err != nil {
+		return []byte{}, []string{}, errors.New("Existing file: " + err.Error())
+	}
+	generatedLines := splitLines(generatedContents)
+	generatedRegions, err := findUserCodeRegions(generatedLines)
+	if err != nil {
+		return []byte{}, []string{}, errors.New("Generated file: " + err.Error())
+	}
+	if len(existingRegions) == 0 {
+		return generatedContents, []string{}, nil
+	}
+
+	userCode := make(map[string][]string)
+	for _, region := range existingRegions {
+		userCode[region.Id] = existingLines[region.Start+1 : region.End]
+	}
+
+	mergedLines := make([]string, 0, len(generatedLines))
+	previousEnd := 0
+	for _, region := range generatedRegions {
+		code, found := userCode[region.Id]
+		if !found {
+			continue
+		}
+		mergedLines = append(mergedLines, generatedLines[previousEnd:region.Start+1]...)
+		mergedLines = append(mergedLines, code...)
+		previousEnd = region.End
+		delete(userCode, region.Id)
+	}
+	mergedLines = append(mergedLines, generatedLines[previousEnd:]...)
+
+	droppedIds := make([]string, 0)
+	for _, region := range existingRegions {
+		if code, dropped := userCode[region.Id]; dropped && strings.TrimSpace(strings.Join(code, "")) != "" {
+			droppedIds = append(droppedIds, region.Id)
+		}
+	}
+	return []byte(strings.Join(mergedLines, "")), droppedIds, nil
+}
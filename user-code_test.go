@@ -0,0 +1,146 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const TestExistingUserCode string = `package com.test;
+// levo:begin-user-code imports
+import com.test.Custom;
+// levo:end-user-code
+public class Cats {
+   // levo:begin-user-code body
+   public void meow() {}
+   // levo:end-user-code
+}
+`
+
+func TestPreserveUserCode(testing *testing.T) {
+	generated := `package com.test.models;
+// levo:begin-user-code imports
+// levo:end-user-code
+public class Cats {
+   private String mName;
+   // levo:begin-user-code body
+   // add your own methods here
+   // levo:end-user-code
+}
+`
+	expecting := `package com.test.models;
+// levo:begin-user-code imports
+import com.test.Custom;
+// levo:end-user-code
+public class Cats {
+   private String mName;
+   // levo:begin-user-code body
+   public void meow() {}
+   // levo:end-user-code
+}
+`
+	merged, droppedIds, err := preserveUserCode([]byte(TestExistingUserCode), []byte(generated))
+	if err != nil {
+		testing.Fatalf("Error when preserving valid user code: %v", err.Error())
+	}
+	if string(merged) != expecting {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", expecting, string(merged))
+	}
+	if len(droppedIds) != 0 {
+		testing.Errorf("No regions should have been dropped. Got %v", droppedIds)
+	}
+
+	//a region that the template no longer produces is reported
+	generated = "package com.test.models;\n// levo:begin-user-code imports\n// levo:end-user-code\n"
+	_, droppedIds, err = preserveUserCode([]byte(TestExistingUserCode), []byte(generated))
+	if err != nil {
+		testing.Errorf("Error when preserving valid user code: %v", err.Error())
+	} else if len(droppedIds) != 1 || droppedIds[0] != "body" {
+		testing.Errorf("Expected region 'body' to be dropped. Got %v", droppedIds)
+	}
+
+	//files without markers are left alone
+	merged, _, err = preserveUserCode([]byte("old\n"), []byte("new\n"))
+	if err != nil || string(merged) != "new\n" {
+		testing.Errorf("Files without user code were changed: %q %v", string(merged), err)
+	}
+}
+
+func TestFindUserCodeRegionsErrors(testing *testing.T) {
+	brokenFiles := []string{
+		"// levo:begin-user-code a\n",
+		"// levo:end-user-code\n",
+		"// levo:begin-user-code a\n// levo:begin-user-code b\n// levo:end-user-code\n",
+		"// levo:begin-user-code a\n// levo:end-user-code\n// levo:begin-user-code a\n// levo:end-user-code\n",
+	}
+	for _, brokenFile := range brokenFiles {
+		if _, err := findUserCodeRegions(splitLines([]byte(brokenFile))); err == nil {
+			testing.Errorf("No error for broken markers:\n%s", brokenFile)
+		}
+	}
+}
+
+func TestOutputFilesKeepsUserCode(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-user-code")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	path := filepath.Join(outputDir, "Cats.java")
+	ioutil.WriteFile(path, []byte(TestExistingUserCode), 0644)
+
+	generated := "package com.test;\n// levo:begin-user-code imports\n// levo:end-user-code\npublic class Cats {\n   // levo:begin-user-code body\n   // levo:end-user-code\n}\n"
+	forceOverwrite = true
+	err := outputFiles([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte(generated)}})
+	if err != nil {
+		testing.Fatalf("Error when writing valid files: %v", err.Error())
+	}
+
+	contents, _ := ioutil.ReadFile(path)
+	if string(contents) != TestExistingUserCode {
+		testing.Errorf("Expecting:\n%s\nGot:\n%s\n", TestExistingUserCode, string(contents))
+	}
+}
+
+func TestDroppedUserCodeIsAskedAbout(testing *testing.T) {
+	defer cleanup()
+	outputDir := testTempDir(testing, "levo-user-code")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+	flag.Set("quiet", "true")
+	ioutil.WriteFile(filepath.Join(outputDir, "Cats.java"), []byte(TestExistingUserCode), 0644)
+
+	generated := "public class Cats {\n   // levo:begin-user-code body\n   // levo:end-user-code\n}\n"
+	plan, err := planOutput([]levo.GeneratedFile{levo.GeneratedFile{FileName: "Cats.java", Body: []byte(generated)}})
+	if err != nil {
+		testing.Fatalf("Error planning files: %v", err.Error())
+	}
+	if !plan[0].DropsUserCode {
+		testing.Fatalf("Dropping the imports region was not detected when planning")
+	}
+	output := bytes.Buffer{}
+	resolver := conflictResolver{prompt: newOverwritePrompt(strings.NewReader("n\n"), &output)}
+	write, err := resolver.resolve(plan[0], nil)
+	if err != nil || write || !strings.Contains(output.String(), "Overwrite?") {
+		testing.Errorf("User code was dropped without asking, even with -quiet: %v %v", write, err)
+	}
+}
@@ -0,0 +1,69 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"fmt"
+	"github.com/cfmobile/levolib"
+	"io"
+)
+
+//verifyGeneratedFiles checks that the files on disk match what levo generates
+//now, without writing anything. Every file that is missing, differs or is no
+//longer generated is listed, followed by a diff, and an OutOfDateError is
+//returned so that CI fails.
+func verifyGeneratedFiles(generatedFiles []levo.GeneratedFile, writer io.Writer) error {
+	plan, err := planOutput(generatedFiles)
+	if err != nil {
+		return wrapLevoError(OutputError, "Error planning files: ", err)
+	}
+
+	outOfDate := 0
+	for _, planned := range plan {
+		if planned.Status == FileCreated {
+			reportOutOfDate(writer, planned.Path, "missing")
+			outOfDate++
+		} else if planned.Status == FileOverwritten {
+			reportOutOfDate(writer, planned.Path, "differs")
+			outOfDate++
+		}
+	}
+	if stalePolicy != STALE_IGNORE {
+		stale, _, err := existingStaleFiles(plan)
+		if err != nil {
+			return wrapLevoError(OutputError, "Error reading manifest: ", err)
+		}
+		for _, entry := range stale {
+			reportOutOfDate(writer, rootPath(entry.Path), "stale")
+			outOfDate++
+		}
+	}
+
+	if outOfDate > 0 {
+		//the diff is left to the file events with -format json
+		if !jsonEvents() {
+			fmt.Fprintln(writer)
+			writeOutputDiff(plan, writer)
+		}
+		return newLevoError(OutOfDateError, fmt.Sprintf("%d generated files are out of date", outOfDate))
+	}
+	summary := runEvent{Event: EVENT_SUMMARY, Counts: map[string]int{"up to date": len(plan)}}
+	reportEvent(writer, summary, fmt.Sprintf("All %d generated files are up to date", len(plan)))
+	return nil
+}
+
+func reportOutOfDate(writer io.Writer, path string, status string) {
+	reportEvent(writer, runEvent{Event: EVENT_FILE, Path: path, Status: status}, fmt.Sprintf("%-9s %s", status, path))
+}
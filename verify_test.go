@@ -0,0 +1,109 @@
+/* Copyright (C) 2014 Pivotal Software, Inc.
+
+All rights reserved. This program and the accompanying materials
+are made available under the terms of the under the Apache License,
+Version 2.0 (the "License”); you may not use this file except in compliance
+with the License. You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"github.com/cfmobile/levolib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyGeneratedFiles(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-verify")
+	defer os.RemoveAll(outputDir)
+	flag.Set("out", outputDir)
+
+	generatedFiles := []levo.GeneratedFile{
+		levo.GeneratedFile{FileName: "Cats.java", Body: []byte("class Cats {}\n")},
+		levo.GeneratedFile{FileName: "Dogs.java", Body: []byte("class Dogs {}\n")},
+	}
+	writer := bytes.Buffer{}
+	err := verifyGeneratedFiles(generatedFiles, &writer)
+	if !isErrorKind(err, OutOfDateError) {
+		testing.Fatalf("Expected missing files to be out of date. Got %v", err)
+	}
+	if !strings.Contains(writer.String(), "missing   "+filepath.Join(outputDir, "Cats.java")) {
+		testing.Errorf("Missing file was not listed:\n%s", writer.String())
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "Cats.java")); !os.IsNotExist(err) {
+		testing.Errorf("Verifying wrote a file")
+	}
+
+	if err := outputFiles(generatedFiles); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	writer.Reset()
+	if err := verifyGeneratedFiles(generatedFiles, &writer); err != nil {
+		testing.Errorf("Up to date files failed verification: %v\n%s", err, writer.String())
+	}
+
+	ioutil.WriteFile(filepath.Join(outputDir, "Dogs.java"), []byte("class Dogs { int legs; }\n"), 0644)
+	writer.Reset()
+	err = verifyGeneratedFiles(generatedFiles, &writer)
+	if exitCodeForError(err) != EXIT_OUT_OF_DATE {
+		testing.Fatalf("Expected exit code %v for a changed file. Got %v", EXIT_OUT_OF_DATE, exitCodeForError(err))
+	}
+	if !strings.Contains(writer.String(), "differs") || !strings.Contains(writer.String(), "-class Dogs { int legs; }") {
+		testing.Errorf("Changed file was not listed with a diff:\n%s", writer.String())
+	}
+
+	//files that are no longer generated are out of date as well
+	writer.Reset()
+	err = verifyGeneratedFiles(generatedFiles[:1], &writer)
+	if !isErrorKind(err, OutOfDateError) || !strings.Contains(writer.String(), "stale     "+filepath.Join(outputDir, "Dogs.java")) {
+		testing.Errorf("Stale file was not listed:\n%s", writer.String())
+	}
+}
+
+func TestVerifyGeneratedFilesOfOneInvocation(testing *testing.T) {
+	defer cleanup()
+	cleanup()
+	outputDir := testTempDir(testing, "levo-verify")
+	defer os.RemoveAll(outputDir)
+	useModel := func(model string) {
+		resetFlags()
+		flag.Set("out", outputDir)
+		flag.Set("template", "m.lt")
+		flag.Set("model", model)
+	}
+	cat := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Cat.java", Body: []byte("class Cat {}\n")}}
+	dog := []levo.GeneratedFile{levo.GeneratedFile{FileName: "Dog.java", Body: []byte("class Dog {}\n")}}
+	useModel(`{"Name": "Cat"}`)
+	if err := outputFiles(cat); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+	useModel(`{"Name": "Dog"}`)
+	if err := outputFiles(dog); err != nil {
+		testing.Fatalf("Error writing files: %v", err.Error())
+	}
+
+	//the project generates with both invocations, and each verifies on its own
+	writer := bytes.Buffer{}
+	if err := verifyGeneratedFiles(dog, &writer); err != nil {
+		testing.Errorf("A file of another invocation failed verification: %v\n%s", err, writer.String())
+	}
+	useModel(`{"Name": "Cat"}`)
+	writer.Reset()
+	if err := verifyGeneratedFiles(cat, &writer); err != nil {
+		testing.Errorf("A file of another invocation failed verification: %v\n%s", err, writer.String())
+	}
+}